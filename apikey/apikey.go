@@ -0,0 +1,167 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"pond/database"
+
+	"gorm.io/gorm"
+)
+
+const keyPrefix = "pk_"
+
+var (
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrDisabled   = errors.New("API client or key is disabled")
+)
+
+// Client is an authenticated caller.
+type Client struct {
+	ID           uint
+	Name         string
+	AllowedTypes []string
+}
+
+// Allows reports whether the client may send reports of reportType.
+func (c *Client) Allows(reportType string) bool {
+	for _, t := range c.AllowedTypes {
+		if strings.EqualFold(t, reportType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash is what is stored for a key.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate returns a new random key and the prefix used to identify it in
+// listings and logs.
+func Generate() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:len(keyPrefix)+8], nil
+}
+
+// Authenticate looks key up by its hash and checks that both the key and its
+// client are enabled and the key is inside its validity window.
+func Authenticate(ctx context.Context, key string, now time.Time) (*Client, error) {
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+	hash := Hash(key)
+	db := database.DBConn.WithContext(ctx)
+
+	var k database.ApiClientKey
+	err := db.First(&k, "key_hash = ?", hash).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if !k.Enabled || now.Before(k.NotBefore) || (k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)) {
+		return nil, ErrDisabled
+	}
+
+	var c database.ApiClient
+	if err := db.First(&c, k.ClientId).Error; err != nil {
+		return nil, err
+	}
+	if !c.Enabled {
+		return nil, ErrDisabled
+	}
+
+	return &Client{ID: c.ID, Name: c.Name, AllowedTypes: splitTypes(c.AllowedTypes)}, nil
+}
+
+// CreateClient registers an enabled client.
+func CreateClient(name string, types []string) (*database.ApiClient, error) {
+	c := database.ApiClient{Name: name, AllowedTypes: strings.Join(types, ","), Enabled: true}
+	if err := database.DBConn.Create(&c).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// IssueKey adds a key to the named client and returns it; the key itself is
+// not stored. Keys the client already has stay valid for overlap so callers
+// can switch over, then expire.
+func IssueKey(clientName string, overlap time.Duration) (string, error) {
+	var c database.ApiClient
+	if err := database.DBConn.First(&c, "name = ?", clientName).Error; err != nil {
+		return "", fmt.Errorf("client %q: %w", clientName, err)
+	}
+
+	key, prefix, err := Generate()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = database.DBConn.Transaction(func(tx *gorm.DB) error {
+		cutoff := now.Add(overlap)
+		if err := tx.Model(&database.ApiClientKey{}).
+			Where("client_id = ? AND enabled = ? AND (expires_at IS NULL OR expires_at > ?)", c.ID, true, cutoff).
+			Update("expires_at", cutoff).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.ApiClientKey{
+			ClientId:  c.ID,
+			KeyHash:   Hash(key),
+			Prefix:    prefix,
+			Enabled:   true,
+			NotBefore: now,
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// SetClientEnabled enables or disables every key of a client at once.
+func SetClientEnabled(clientName string, enabled bool) error {
+	res := database.DBConn.Model(&database.ApiClient{}).Where("name = ?", clientName).Update("enabled", enabled)
+	if res.Error == nil && res.RowsAffected == 0 {
+		return fmt.Errorf("client %q not found", clientName)
+	}
+	return res.Error
+}
+
+// RevokeKey disables a single key by its prefix.
+func RevokeKey(prefix string) error {
+	res := database.DBConn.Model(&database.ApiClientKey{}).Where("prefix = ?", prefix).Update("enabled", false)
+	if res.Error == nil && res.RowsAffected == 0 {
+		return fmt.Errorf("key %q not found", prefix)
+	}
+	return res.Error
+}
+
+func splitTypes(s string) []string {
+	var types []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
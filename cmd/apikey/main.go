@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"pond/apikey"
+	"pond/database"
+	"pond/signing"
+
+	"github.com/joho/godotenv"
+)
+
+// apikey manages the API client registry:
+//
+//	go run ./cmd/apikey -create upstream-a -types Transfer,Income
+//	go run ./cmd/apikey -rotate upstream-a -overlap 72h
+//	go run ./cmd/apikey -disable upstream-a
+//	go run ./cmd/apikey -revoke pk_AbCdEfGh
+func main() {
+	create := flag.String("create", "", "register a client and print its first key")
+	types := flag.String("types", "Transfer,Income", "report types the new client may send")
+	rotate := flag.String("rotate", "", "print a new key for a client; older keys expire after -overlap")
+	overlap := flag.Duration("overlap", 72*time.Hour, "how long older keys stay valid after -rotate")
+	disable := flag.String("disable", "", "disable a client")
+	enable := flag.String("enable", "", "enable a client")
+	revoke := flag.String("revoke", "", "disable one key by its prefix")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+	if err := database.Open(); err != nil {
+		log.Fatal(err)
+	}
+	if err := database.Migrate(); err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case *create != "":
+		if _, err := apikey.CreateClient(*create, strings.Split(*types, ",")); err != nil {
+			log.Fatal(err)
+		}
+		printKey(*create, 0)
+	case *rotate != "":
+		printKey(*rotate, *overlap)
+	case *disable != "":
+		must(apikey.SetClientEnabled(*disable, false))
+	case *enable != "":
+		must(apikey.SetClientEnabled(*enable, true))
+	case *revoke != "":
+		must(apikey.RevokeKey(*revoke))
+	default:
+		flag.Usage()
+	}
+}
+
+func printKey(client string, overlap time.Duration) {
+	key, err := apikey.IssueKey(client, overlap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// แสดงครั้งเดียว ใน db เก็บแค่ hash
+	fmt.Println("key:", key)
+	if master := os.Getenv("REQUEST_SIGNING_MASTER_KEY"); master != "" {
+		fmt.Println("signing secret:", string(signing.SecretFor([]byte(master), key)))
+	}
+}
+
+func must(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"pond/mailer"
+)
+
+type rejectedAddress struct {
+	Address string
+	Reason  string
+}
+
+// parseRecipients splits a comma separated list from the info table and
+// keeps only addresses that net/mail accepts and that carry no control
+// characters. Everything else is returned as rejected with a reason.
+func parseRecipients(list string) ([]string, []rejectedAddress) {
+	var valid []string
+	var rejected []rejectedAddress
+
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if err := mailer.CheckHeaderValue(raw); err != nil {
+			rejected = append(rejected, rejectedAddress{Address: sanitizeForLog(raw), Reason: err.Error()})
+			continue
+		}
+		addr, err := mail.ParseAddress(raw)
+		if err != nil {
+			rejected = append(rejected, rejectedAddress{Address: raw, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, addr.Address)
+	}
+	return valid, rejected
+}
+
+// sanitizeForLog makes a rejected value safe to echo back in JSON and logs.
+func sanitizeForLog(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func rejectedResults(p MailPayload) []MailSendResult {
+	var results []MailSendResult
+	for _, r := range p.Rejected {
+		results = append(results, MailSendResult{
+			TransferId:  p.TransferId,
+			Email:       r.Address,
+			ShortLink:   p.ShortLink,
+			FullLink:    p.FullLink,
+			Status:      "FAIL",
+			Error:       fmt.Sprintf("invalid recipient address: %s", r.Reason),
+			Corporation: strings.Join(p.Corporation, ","),
+			Corpemail:   strings.Join(p.Corpemail, ","),
+		})
+	}
+	return results
+}
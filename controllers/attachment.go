@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"pond/env"
+	"pond/mailer"
+)
+
+const defaultMaxAttachmentBytes = 5 << 20
+
+var pdfClient = &http.Client{Timeout: 30 * time.Second}
+
+func maxAttachmentBytes() int64 {
+	return env.Int64("MAX_ATTACHMENT_BYTES", defaultMaxAttachmentBytes)
+}
+
+func pdfFilename(transferId string, date time.Time) string {
+	return fmt.Sprintf("OPS_%s_%s.pdf", transferId, date.Format("20060102"))
+}
+
+// fetchReportPDF downloads the report behind fullUrl and names it after the
+// last day of the report period. It fails when the download is larger than
+// MAX_ATTACHMENT_BYTES or is not a PDF, so the caller can fall back to
+// sending the link only.
+func fetchReportPDF(transferId, fullUrl string, periodEnd time.Time) (*mailer.Attachment, error) {
+	resp, err := pdfClient.Get(fullUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("download report: HTTP %s", resp.Status)
+	}
+
+	limit := maxAttachmentBytes()
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("report is %d bytes, over limit %d", resp.ContentLength, limit)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("report is over limit %d bytes", limit)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil, fmt.Errorf("download is not a PDF")
+	}
+
+	return &mailer.Attachment{
+		Filename:    pdfFilename(transferId, periodEnd),
+		ContentType: "application/pdf",
+		Data:        data,
+	}, nil
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"pond/apikey"
+	"pond/env"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const apiClientLocal = "apiClient"
+
+// legacyKeyAllowed reports whether the shared SECRECT_KEY is still accepted.
+// It is off unless ALLOW_LEGACY_SECRET_KEY=true, set only while a caller is
+// being moved to its own key in api_client.
+func legacyKeyAllowed() bool {
+	return env.Bool("ALLOW_LEGACY_SECRET_KEY", false)
+}
+
+func matchesLegacyKey(key string) bool {
+	secret := os.Getenv("SECRECT_KEY")
+	if !legacyKeyAllowed() || secret == "" || key == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(secret)) == 1
+}
+
+// authenticateKey resolves an X-API-Key against the client registry, or
+// the legacy shared secret while that is still allowed.
+func authenticateKey(ctx context.Context, key string) (*apikey.Client, error) {
+	if matchesLegacyKey(key) {
+		return &apikey.Client{Name: "legacy", AllowedTypes: []string{"Transfer", "Income"}}, nil
+	}
+	client, err := apikey.Authenticate(ctx, key, time.Now())
+	if err != nil && !errors.Is(err, apikey.ErrInvalidKey) && !errors.Is(err, apikey.ErrDisabled) {
+		log.Printf("[AUTH] key lookup error: %v", err)
+	}
+	return client, err
+}
+
+// authenticateRequest uses the X-API-Key header. Callers that have not moved
+// to the header yet may still send the legacy key in the JSON body.
+func authenticateRequest(c *fiber.Ctx, bodyKey string) (*apikey.Client, error) {
+	if key := c.Get("X-API-Key"); key != "" {
+		return authenticateKey(c.UserContext(), key)
+	}
+	if matchesLegacyKey(bodyKey) {
+		return authenticateKey(c.UserContext(), bodyKey)
+	}
+	return nil, apikey.ErrInvalidKey
+}
+
+// RequireAPIClient guards read endpoints with the X-API-Key header.
+func RequireAPIClient(c *fiber.Ctx) error {
+	client, err := authenticateKey(c.UserContext(), c.Get("X-API-Key"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid Key",
+		})
+	}
+	c.Locals(apiClientLocal, client)
+	return c.Next()
+}
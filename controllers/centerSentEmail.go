@@ -1,21 +1,21 @@
 package controllers
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net"
-	"net/http"
-	"net/smtp"
+	"net/textproto"
 	"os"
 	"strings"
 
 	"time"
 
 	"pond/database"
+	"pond/env"
+	"pond/format"
+	"pond/mailer"
+	"pond/repository"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -32,17 +32,21 @@ type DetailRes struct {
 }
 
 type ReceiveResFormat struct {
-	Key    string      `json:"key" validate:"required"`
-	Type   string      `json:"type" validate:"required,oneof=Transfer Income"`
-	Detail []DetailRes `json:"details" validate:"min=1,dive"`
+	Key       string      `json:"key"` // legacy; use the X-API-Key header
+	Type      string      `json:"type" validate:"required,oneof=Transfer Income"`
+	Detail    []DetailRes `json:"details" validate:"min=1,dive"`
+	Async     bool        `json:"async"`
+	AttachPdf bool        `json:"attach_pdf"` // แนบ PDF ทุกรายการ นอกเหนือจากที่ตั้งไว้ราย corporation
+	Force     bool        `json:"force"`      // ส่งซ้ำแม้รายงานงวดเดียวกันเคยส่งสำเร็จแล้ว
 }
 
 type SentNext struct {
 	TransferIdSentOut string `json:"transferId" validate:"required"`
 }
 type TokenWithId struct {
-	TransferId string `json:"transfer_id"`
-	Token      string `json:"token"`
+	TransferId  string `json:"transfer_id"`
+	Token       string `json:"token"`
+	RecipientId string `json:"recipient_id,omitempty"`
 }
 type APIResponseToUsers struct {
 	TransferId  string `json:"transfer_id"`
@@ -51,6 +55,14 @@ type APIResponseToUsers struct {
 	Shoturl     string `json:"short_url"`
 	Corporation string `json:"corporation_name"`
 	Corpemail   string `json:"corporation_email"`
+	AttachPdf   bool   `json:"attach_pdf"`
+	Type        string `json:"type"`
+	StartDate   string `json:"start_date"`
+	EndDate     string `json:"end_date"`
+	RecipientId string `json:"recipient_id"`
+	Lang        string `json:"lang"`
+	BuddhistEra bool   `json:"buddhist_era"`
+	detail      int    // index in ReceiveResFormat.Detail
 }
 
 type MailDetail struct {
@@ -65,6 +77,11 @@ type MailPayload struct {
 	FromHeader  string
 	Subject     string
 	Body        string
+	TextBody    string
+	Type        string
+	StartDate   string
+	EndDate     string
+	RecipientId string
 	To          []string
 	Bcc         []string
 	ShortLink   string
@@ -72,17 +89,31 @@ type MailPayload struct {
 	TransferId  string
 	Corporation []string
 	Corpemail   []string
+	AttachPdf   bool
+	Reserved    bool // the request reserved this report, see reserveReports
+	Rejected    []rejectedAddress
 }
 
+// MailSendResult.Stage บอกว่า FAIL ตั้งแต่ขั้นไหน
+const (
+	stageToken  = "token"
+	stageRender = "render"
+	stageOutbox = "outbox"
+)
+
 type MailSendResult struct {
-	TransferId  string `json:"transfer_id"`
-	Email       string `json:"receiver_email"`
-	ShortLink   string `json:"short_link"`
-	FullLink    string `json:"full_link"`
-	Status      string `json:"status"` // SUCCESS | FAIL
-	Error       string `json:"error,omitempty"`
-	Corporation string `json:"corporation_name"`
-	Corpemail   string `json:"corporation_email"`
+	TransferId  string    `json:"transfer_id"`
+	Email       string    `json:"receiver_email"`
+	ShortLink   string    `json:"short_link"`
+	FullLink    string    `json:"full_link"`
+	Status      string    `json:"status"` // SUCCESS | FAIL | SKIPPED
+	Stage       string    `json:"stage,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Corporation string    `json:"corporation_name"`
+	Corpemail   string    `json:"corporation_email"`
+	detail      int       // index in ReceiveResFormat.Detail, keeps results in request order
+	report      reportKey // report of a failure before the outbox, stored on its row
+	reserved    bool      // the request holds the reservation of report
 }
 
 func HandleAPI(c *fiber.Ctx) error {
@@ -105,12 +136,18 @@ func HandleAPI(c *fiber.Ctx) error {
 			"detail": err.Error(),
 		})
 	}
-	systemKey := os.Getenv("SECRECT_KEY")
-	if req.Key != systemKey {
+	client, err := authenticateRequest(c, req.Key)
+	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid Key",
 		})
 	}
+	if !client.Allows(req.Type) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Type not allowed for this client",
+		})
+	}
+	log.Printf("[API] %s request from client %s", req.Type, client.Name)
 
 	if req.Type != "Transfer" && req.Type != "transfer" && req.Type != "Income" && req.Type != "income" {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -118,166 +155,198 @@ func HandleAPI(c *fiber.Ctx) error {
 		})
 	}
 
-	urlResults, err := GenToken(req)
+	if err := validateDetails(req.Detail); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"detail": err.Error(),
+		})
+	}
+
+	idem, replied, err := claimIdempotencyKey(c, client.Name)
+	if replied {
+		return err
+	}
+	reply := func(status int, body fiber.Map) error {
+		finishIdempotencyKey(idem, status, body)
+		return c.Status(status).JSON(body)
+	}
+
+	var skipped []MailSendResult
+	kept := make([]int, len(req.Detail))
+	for i := range kept {
+		kept[i] = i
+	}
+	if !req.Force {
+		req.Detail, kept, skipped, err = reserveReports(req.Type, req.Detail)
+		if err != nil {
+			return reply(500, fiber.Map{
+				"error":  "Failed to check previous deliveries",
+				"detail": err.Error(),
+			})
+		}
+		for _, s := range skipped {
+			log.Printf("%s [API] skipped, %s", s.TransferId, s.Error)
+		}
+	}
+	if len(req.Detail) == 0 {
+		return reply(200, fiber.Map{
+			"responseCode": "00",
+			"summary":      summarize(len(skipped), skipped),
+			"results":      skipped,
+		})
+	}
+
+	if req.Async {
+		job, err := submitJob(req, client.Name)
+		if err != nil {
+			if !req.Force {
+				releaseDetails(req.Type, req.Detail)
+			}
+			if errors.Is(err, errJobQueueFull) {
+				return reply(fiber.StatusServiceUnavailable, fiber.Map{
+					"error": "Job queue is full, try again later",
+				})
+			}
+			return reply(500, fiber.Map{
+				"error":  "Failed to queue job",
+				"detail": err.Error(),
+			})
+		}
+		return reply(fiber.StatusAccepted, fiber.Map{
+			"responseCode": "00",
+			"job_id":       job.ID,
+			"status":       job.Status,
+			"skipped":      skipped,
+		})
+	}
+
+	urlResults, tokenFailures, err := GenToken(c.UserContext(), req)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
+		if !req.Force {
+			releaseDetails(req.Type, req.Detail)
+		}
+		return reply(500, fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
 
-	mailResults := processMailSend(urlResults)
+	mailResults := mergeSkipped(skipped, kept, processMailSend("", urlResults, tokenFailures, !req.Force))
 
+	return reply(200, fiber.Map{
+		"responseCode": "00",
+		"summary":      summarize(len(mailResults), mailResults),
+		"results":      mailResults,
+	})
+}
+
+func summarize(total int, results []MailSendResult) fiber.Map {
 	success := 0
 	fail := 0
-	for _, r := range mailResults {
-		if r.Status == "SUCCESS" {
+	skipped := 0
+	for _, r := range results {
+		switch r.Status {
+		case "SUCCESS":
 			success++
-		} else {
+		case "FAIL":
 			fail++
+		case statusSkipped:
+			skipped++
 		}
 	}
-
-	return c.Status(200).JSON(fiber.Map{
-		"responseCode": "00",
-		"summary": fiber.Map{
-			"total":   len(mailResults),
-			"success": success,
-			"fail":    fail,
-		},
-		"results": mailResults,
-	})
+	return fiber.Map{
+		"total":   total,
+		"success": success,
+		"fail":    fail,
+		"skipped": skipped,
+	}
 }
 
-func GenToken(req ReceiveResFormat) ([]APIResponseToUsers, error) {
+// GenToken requests a token and link for every detail. Transfers whose
+// token could not be generated come back as FAIL results with stage "token".
+// Every link and failure carries the index of its detail.
+func GenToken(ctx context.Context, req ReceiveResFormat) ([]APIResponseToUsers, []MailSendResult, error) {
 	log.Printf("Received Type: %s with %d details", req.Type, len(req.Detail))
-	var IdResult []SentNext
 
-	for _, v := range req.Detail {
-		re := SentNext{
-			TransferIdSentOut: v.TransferId,
-		}
-		IdResult = append(IdResult, re) // output jaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
-	}
-
-	type APIResponse struct {
-		Token string `json:"token"`
-	}
+	tokens, errs := issueTokens(ctx, req.Type, req.Detail)
 
 	var tkid []TokenWithId
-	for i, v := range IdResult {
-		payload, _ := json.Marshal(map[string]string{"transferId": v.TransferIdSentOut})
-		resp, err := http.Post(os.Getenv("URL_ONE_GENERATE_TOKEN"), "application/json", bytes.NewBuffer(payload))
-		if err != nil {
-			log.Printf("Error sending request for item %d: %v", i, err)
+	var index []int
+	var failed []MailSendResult
+	for i, d := range req.Detail {
+		if errs[i] != nil {
+			log.Printf("%s [TOKEN] failed: %v", d.TransferId, errs[i])
+			failed = append(failed, MailSendResult{
+				TransferId: d.TransferId,
+				Status:     "FAIL",
+				Stage:      stageToken,
+				Error:      errs[i].Error(),
+				detail:     i,
+				report:     newReportKey(req.Type, d),
+			})
+			continue
 		}
-		func() {
-			defer resp.Body.Close()
-
-			if resp.StatusCode != 200 {
-				log.Printf("Request failed status: %d", resp.StatusCode)
-				return
-			}
-
-			var apiRes APIResponse
-			if err := json.NewDecoder(resp.Body).Decode(&apiRes); err != nil {
-				log.Printf("Error decoding response: %v", err)
-				return
-			}
-
-			newItem := TokenWithId{
-				TransferId: v.TransferIdSentOut,
-				Token:      apiRes.Token,
-			}
-
-			tkid = append(tkid, newItem)
-		}()
+		tkid = append(tkid, TokenWithId{TransferId: d.TransferId, Token: tokens[i], RecipientId: d.RecipientId})
+		index = append(index, i)
 	}
 
-	urlResultList, err := UrlCreate(tkid)
+	urlResultList, err := UrlCreate(ctx, tkid)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	for i := range urlResultList {
+		d := req.Detail[index[i]]
+		urlResultList[i].detail = index[i]
+		urlResultList[i].Type = req.Type
+		urlResultList[i].StartDate = d.StartDate
+		urlResultList[i].EndDate = d.EndDate
+		urlResultList[i].RecipientId = d.RecipientId
+		if req.AttachPdf {
+			urlResultList[i].AttachPdf = true
+		}
 	}
 
-	return urlResultList, nil
+	return urlResultList, failed, nil
 }
 
 ////////////////////////////////////////////////////////////////////////
 
-func UrlCreate(tkid []TokenWithId) ([]APIResponseToUsers, error) {
-	var res []APIResponseToUsers
-	for _, token := range tkid {
-		fullUrl := os.Getenv("URL_LINK_FOLLOW_TOKEN") + token.Token
-
-		var shortUrl string = ""
-		maxRetries := 3
+func UrlCreate(ctx context.Context, tkid []TokenWithId) ([]APIResponseToUsers, error) {
+	res := make([]APIResponseToUsers, len(tkid))
+	errs := make([]error, len(tkid))
+	runPool(env.Int("SHORTLINK_WORKERS", 4), len(tkid), func(i int) {
+		res[i], errs[i] = createUrl(ctx, tkid[i])
+	})
 
-		reqObj := map[string]string{
-			"link": fullUrl,
-		}
-		jsonBody, err := json.Marshal(reqObj)
+	for _, err := range errs {
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-
-			resp, err := http.Post(
-				os.Getenv("URL_ONE_GENERATE_SHOT_LINK"),
-				"application/json",
-				bytes.NewBuffer(jsonBody),
-			)
-			if resp.StatusCode != 200 {
-				log.Printf("Shortlink's Attempt %d/%d failed (HTTP Status %s)", attempt, maxRetries, resp.Status)
-				shortUrl = ""
-				if attempt < maxRetries {
-					time.Sleep(3 * time.Second)
-				}
-				continue
-			}
-			if err == nil {
-				defer resp.Body.Close()
-
-				bodyBytes, _ := io.ReadAll(resp.Body)
-
-				var slResp struct {
-					Data struct {
-						ShortLink string `json:"short-link"`
-					} `json:"data"`
-				}
-
-				if err := json.Unmarshal(bodyBytes, &slResp); err == nil {
-					shortUrl = slResp.Data.ShortLink
-				}
-				break
-			}
-
-		}
-		// Add Corporation field to the response
-		db := database.DBConn
-		// qeury to get corporation name from transfer id form token.transferId
-		var corpQuery struct {
-			Name  string `gorm:"column:NAME"`
-			Email string `gorm:"column:EMAIL"`
-		}
-
-		err = db.Table("info").Select("NAME", "EMAIL").Where("TRANSFER_ID = ?", token.TransferId).Scan(&corpQuery).Error
+	return res, nil
+}
 
-		if err != nil {
-			log.Printf("Error querying corporation for Transfer ID %s: %v", token.TransferId, err)
-		}
+func createUrl(ctx context.Context, token TokenWithId) (APIResponseToUsers, error) {
+	fullUrl := os.Getenv("URL_LINK_FOLLOW_TOKEN") + token.Token
+	shortUrl := shortenLink(ctx, token.TransferId, fullUrl)
 
-		r := APIResponseToUsers{
-			TransferId:  token.TransferId,
-			Token:       token.Token,
-			Fullurl:     fullUrl,
-			Shoturl:     shortUrl,
-			Corporation: corpQuery.Name, // Set the corporation name here after querying the database
-			Corpemail:   corpQuery.Email,
-		}
-		res = append(res, r)
+	// Add Corporation field to the response
+	corpQuery, err := repository.FindRecipient(token.TransferId, token.RecipientId)
+	if err != nil {
+		log.Printf("Error querying corporation for Transfer ID %s: %v", token.TransferId, err)
 	}
 
-	return res, nil
+	return APIResponseToUsers{
+		TransferId:  token.TransferId,
+		Token:       token.Token,
+		Fullurl:     fullUrl,
+		Shoturl:     shortUrl,
+		Corporation: corpQuery.Name, // Set the corporation name here after querying the database
+		Corpemail:   corpQuery.Email,
+		AttachPdf:   corpQuery.AttachPdf,
+		Lang:        corpQuery.Lang,
+		BuddhistEra: corpQuery.BuddhistEra,
+	}, nil
 }
 
 func cleanEmail(emails string) []string {
@@ -292,42 +361,101 @@ func cleanEmail(emails string) []string {
 	return result
 }
 
-func processMailSend(urlResults []APIResponseToUsers) []MailSendResult {
+// processMailSend renders, stores and sends the output of GenToken. reserved
+// says the caller holds the reservations of these reports. Results
+// come back in the order of the request's details.
+func processMailSend(jobId string, urlResults []APIResponseToUsers, tokenFailures []MailSendResult, reserved bool) []MailSendResult {
 
 	var results []MailSendResult
 	var failedAccounts []string
 
-	for _, r := range urlResults {
+	// GenToken แบ่งทุก detail เป็นได้ link หรือ FAIL อย่างใดอย่างหนึ่ง
+	n := len(urlResults) + len(tokenFailures)
+	links := make([]*APIResponseToUsers, n)
+	for i := range urlResults {
+		links[urlResults[i].detail] = &urlResults[i]
+	}
+	sent := make([]MailSendResult, n)
+	for _, f := range tokenFailures {
+		f.reserved = reserved
+		sent[f.detail] = f
+	}
 
+	// เขียนทุกฉบับลง outbox ก่อนเริ่มส่ง ฉบับที่ render หรือเขียนไม่ได้ก็ลงเป็น FAIL
+	rows := make([]*database.MailOutbox, n)
+	rejected := make([][]MailSendResult, n)
+	for i := 0; i < n; i++ {
+		if links[i] == nil {
+			recordFailure(jobId, sent[i])
+			continue
+		}
+		r := *links[i]
+		key := newReportKey(r.Type, DetailRes{TransferId: r.TransferId, RecipientId: r.RecipientId, StartDate: r.StartDate, EndDate: r.EndDate})
 		var mail MailDetail
-		payload := gotoMail(&mail, r)
-
-		var err error
-		
-		if len(payload.To) == 0 || payload.To[0] == "" {
-			err = fmt.Errorf("no valid recipient email found for transfer_id: %s", r.TransferId)
-		} else {
-			err = sendMail(payload)
+		payload, err := gotoMail(&mail, r)
+		if err != nil {
+			log.Printf("%s [MAIL] render error: %v", r.TransferId, err)
+			sent[i] = MailSendResult{
+				TransferId:  r.TransferId,
+				Email:       r.Corpemail,
+				ShortLink:   r.Shoturl,
+				FullLink:    r.Fullurl,
+				Status:      "FAIL",
+				Stage:       stageRender,
+				Error:       err.Error(),
+				Corporation: r.Corporation,
+				Corpemail:   r.Corpemail,
+				detail:      i,
+				report:      key,
+				reserved:    reserved,
+			}
+			recordFailure(jobId, sent[i])
+			continue
+		}
+		payload.Reserved = reserved
+		rejected[i] = recordRejected(jobId, payload)
+		for j := range rejected[i] {
+			rejected[i][j].detail = i
 		}
 
-		result := MailSendResult{
-			TransferId:  r.TransferId,
-			Email:       strings.Join(payload.To, ","),
-			ShortLink:   payload.ShortLink,
-			FullLink:    payload.FullLink,
-			Corporation: strings.Join(payload.Corporation, ","),
-			Corpemail:   strings.Join(payload.Corpemail, ","),
+		row, err := enqueueOutbox(jobId, payload)
+		if err != nil {
+			log.Printf("%s [OUTBOX] enqueue error: %v", r.TransferId, err)
+			sent[i] = MailSendResult{
+				TransferId:  r.TransferId,
+				Email:       strings.Join(payload.To, ","),
+				ShortLink:   payload.ShortLink,
+				FullLink:    payload.FullLink,
+				Status:      "FAIL",
+				Stage:       stageOutbox,
+				Error:       fmt.Sprintf("cannot write mail to outbox: %v", err),
+				Corporation: strings.Join(payload.Corporation, ","),
+				Corpemail:   strings.Join(payload.Corpemail, ","),
+				detail:      i,
+				report:      key,
+				reserved:    reserved,
+			}
+			recordFailure(jobId, sent[i])
+			continue
 		}
+		rows[i] = row
+	}
 
-		if err != nil  {
-			result.Status = "FAIL"
-			result.Error = err.Error()
-			failedAccounts = append(failedAccounts, result.Corporation)
-		} else {
-			result.Status = "SUCCESS"
+	runPool(env.Int("SMTP_WORKERS", 2), n, func(i int) {
+		if rows[i] != nil {
+			sent[i] = deliverOutbox(rows[i])
+			sent[i].detail = i
 		}
+	})
 
+	for i, result := range sent {
 		results = append(results, result)
+		results = append(results, rejected[i]...)
+		if result.Stage == stageToken {
+			failedAccounts = append(failedAccounts, result.TransferId)
+		} else if result.Status != "SUCCESS" || len(rejected[i]) > 0 {
+			failedAccounts = append(failedAccounts, result.Corporation)
+		}
 	}
 
 	if len(failedAccounts) > 0 {
@@ -344,163 +472,112 @@ func generateCaseNumber() string {
 }
 
 // เช็ค error หลังเชื่อม db
-func gotoMail(m *MailDetail, res APIResponseToUsers) MailPayload {
-
-	m.AccountName = "Name"
-	m.MinDateTime = "2026-01-01"
-	m.MaxDateTime = "2026-01-02"
-	m.SumTxnCount = "100"
-	m.SumTxnAmount = "1000"
+func gotoMail(m *MailDetail, res APIResponseToUsers) (MailPayload, error) {
 
-	link := res.Shoturl
-	if link == "" {
-		link = "not available"
+	if err := loadMailDetail(m, res.TransferId, res.Corporation, res.StartDate, res.EndDate); err != nil {
+		return MailPayload{}, err
 	}
 
 	fromName := os.Getenv("MAIL_FROM_NAME")
 	smtpFrom := os.Getenv("MAIL_FROM")
 
-	body := fmt.Sprintf(
-		`<p>เรียน %s,</p><br/>
-        <p>ทางบริษัทฯ ส่วนงานการรับชำระเงิน ได้ส่งรายงานการโอนเงิน Online Payment Services (OPS) ประจำวันที่ %s มาให้ท่าน โดยมีรายละเอียดดังนี้</p><br/><br/>
-        <p>จำนวนรายการ : %s รายการ</p>
-        <p>ยอดรับชำระเงิน : %s บาท</p><br/>
-        <p>ทั้งนี้ สามารถดาวน์โหลดรายละเอียดการรับเงินได้ที่ %s</p><br/>
-        <p>หากท่านต้องการข้อมูลเพิ่มเติม โปรดติดต่อ ทางบริษัทฯ ส่วนงานการรับชำระเงิน ผ่านช่องทางต่าง ๆ ดังนี้</p>
-        <p>E-mail : online-support@inet.co.th</p>
-        <p>ขอแสดงความนับถือ</p>
-        <p>บริษัทฯ ส่วนงานการรับชำระเงิน</p>`,
-		res.Corporation, time.Now().Format("02/01/2006"), m.SumTxnCount, m.SumTxnAmount, link)
+	loc := format.NewLocale(res.Lang, res.BuddhistEra)
+	data, err := reportData(*m, loc)
+	if err != nil {
+		return MailPayload{}, err
+	}
+	data.TransferId = res.TransferId
+	data.Link = res.Shoturl
+	if data.Link == "" {
+		data.Link = res.Fullurl
+	}
+
+	subject, body, text, err := renderMail(reportTemplate(res.Type), loc.Lang, data)
+	if err != nil {
+		return MailPayload{}, err
+	}
+
+	to, rejected := parseRecipients(res.Corpemail)
+	for _, r := range rejected {
+		log.Printf("%s [MAIL] invalid recipient %q: %s", res.TransferId, r.Address, r.Reason)
+	}
 
 	return MailPayload{
-		FromHeader: fmt.Sprintf("%s <%s>", fromName, smtpFrom),
-		Subject:    "รายงานโอนเงินกลับประจำวัน",
-		Body:       body,
-		To:         cleanEmail(res.Corpemail),
+		FromHeader:  fmt.Sprintf("%s <%s>", fromName, smtpFrom),
+		Subject:     subject,
+		Body:        body,
+		TextBody:    text,
+		Type:        res.Type,
+		StartDate:   m.MinDateTime,
+		EndDate:     m.MaxDateTime,
+		RecipientId: res.RecipientId,
+		To:          to,
 		//		To:         cleanEmails("boxblue779@gmail.com"),
 		// Bcc:         cleanEmails(os.Getenv("MAIL_BCC")),
-		ShortLink:   link,
+		ShortLink:   res.Shoturl, // ว่างถ้าย่อไม่ได้ ในเมลใช้ full link แทน
 		FullLink:    res.Fullurl,
 		TransferId:  res.TransferId,
 		Corporation: cleanEmail(res.Corporation),
 		Corpemail:   cleanEmail(res.Corpemail),
-	}
+		AttachPdf:   res.AttachPdf,
+		Rejected:    rejected,
+	}, nil
 
 }
 
-func sendMail(p MailPayload) error {
+// sendMail returns the SMTP server's last reply next to the outcome.
+func sendMail(p MailPayload) (string, error) {
 
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
 	smtpFrom := os.Getenv("MAIL_FROM")
 
 	allRecipients := append(p.To, p.Bcc...)
 
-	msg := []byte(
-		"Return-Path: " + smtpFrom + "\r\n" +
-			"From: " + p.FromHeader + "\r\n" +
-			"To: " + strings.Join(p.To, ",") + "\r\n" +
-			"Subject: " + p.Subject + "\r\n" +
-			"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
-			"Message-ID: <" + fmt.Sprintf("%d", time.Now().UnixNano()) + "@thaidotcompayment.co.th>\r\n" +
-			"MIME-Version: 1.0\r\n" +
-			"Content-Type: text/html; charset=UTF-8\r\n\r\n" +
-			p.Body,
-	)
+	var attachments []mailer.Attachment
+	if p.AttachPdf {
+		// โหลดไม่ได้หรือไฟล์ใหญ่เกิน ส่งแค่ลิงก์
+		var att *mailer.Attachment
+		_, end, err := reportPeriod(p.StartDate, p.EndDate)
+		if err == nil {
+			att, err = fetchReportPDF(p.TransferId, p.FullLink, end)
+		}
+		if err != nil {
+			log.Printf("%s [SMTP] attach pdf skipped, sending link only: %v", p.TransferId, err)
+		} else {
+			attachments = append(attachments, *att)
+		}
+	}
+
+	msg, err := buildMessage(smtpFrom, p.FromHeader, p.To, p.Subject, p.Body, p.TextBody, attachments...)
+	if err != nil {
+		return "", err
+	}
 
 	maxRetries := 3
 	var lastErr error
+	var lastReply string
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-
-		conn, err := net.Dial("tcp", smtpHost+":"+smtpPort)
-		if err != nil {
-			lastErr = err
-			log.Printf("%s [SMTP] attempt %d/%d dial error: %v", p.TransferId, attempt, maxRetries, err)
-			time.Sleep(time.Duration(attempt) * time.Second)
-			continue
-		}
-
-		client, err := smtp.NewClient(conn, smtpHost)
+		reply, err := getMailer().SendWithResponse(smtpFrom, allRecipients, msg)
 		if err != nil {
-			conn.Close()
 			lastErr = err
-			log.Printf("%s [SMTP] attempt %d/%d client error: %v", p.TransferId, attempt, maxRetries, err)
-			time.Sleep(time.Duration(attempt) * time.Second)
-			continue
-		}
-
-		if ok, _ := client.Extension("STARTTLS"); ok {
-			if err := client.StartTLS(&tls.Config{ServerName: smtpHost}); err != nil {
-				client.Quit()
-				conn.Close()
-				lastErr = err
-				log.Printf("%s [SMTP] attempt %d/%d starttls error: %v", p.TransferId, attempt, maxRetries, err)
-				time.Sleep(time.Duration(attempt) * time.Second)
-				continue
+			var tpErr *textproto.Error
+			if errors.As(err, &tpErr) {
+				lastReply = tpErr.Error()
 			}
-		}
-
-		if err := client.Auth(smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)); err != nil {
-			client.Quit()
-			conn.Close()
-			lastErr = err
-			log.Printf("%s [SMTP] attempt %d/%d auth error: %v", p.TransferId, attempt, maxRetries, err)
-			time.Sleep(time.Duration(attempt) * time.Second)
-			continue
-		}
-
-		if err := client.Mail(smtpFrom); err != nil {
-			client.Quit()
-			conn.Close()
-			lastErr = err
-			log.Printf("%s [SMTP] attempt %d/%d mail from error: %v", p.TransferId, attempt, maxRetries, err)
-			time.Sleep(time.Duration(attempt) * time.Second)
-			continue
-		}
-
-		for _, addr := range allRecipients {
-			if err := client.Rcpt(addr); err != nil {
-				client.Quit()
-				conn.Close()
-				lastErr = err
-				log.Printf("%s [SMTP] attempt %d/%d rcpt error: %v", p.TransferId, attempt, maxRetries, err)
-				time.Sleep(time.Duration(attempt) * time.Second)
-				continue
+			log.Printf("%s [SMTP] attempt %d/%d send error: %v", p.TransferId, attempt, maxRetries, err)
+			if errors.Is(err, mailer.ErrAfterData) {
+				// server อาจรับไปแล้ว ส่งซ้ำลูกค้าจะได้เมลซ้ำ
+				return lastReply, err
 			}
-		}
-
-		w, err := client.Data()
-		if err != nil {
-			client.Quit()
-			conn.Close()
-			lastErr = err
-			log.Printf("%s [SMTP] attempt %d/%d data error: %v", p.TransferId, attempt, maxRetries, err)
 			time.Sleep(time.Duration(attempt) * time.Second)
 			continue
 		}
 
-		if _, err := w.Write(msg); err != nil {
-			w.Close()
-			client.Quit()
-			conn.Close()
-			lastErr = err
-			log.Printf("%s [SMTP] attempt %d/%d write error: %v", p.TransferId, attempt, maxRetries, err)
-			time.Sleep(time.Duration(attempt) * time.Second)
-			continue
-		}
-
-		w.Close()
-		client.Quit()
-		conn.Close()
-
-		log.Printf("%s [SMTP] send success on attempt %d", p.TransferId, attempt)
-		return nil
+		log.Printf("%s [SMTP] send success on attempt %d: %s", p.TransferId, attempt, reply)
+		return reply, nil
 	}
 
-	return fmt.Errorf("smtp failed after %d retries: %w", maxRetries, lastErr)
+	return lastReply, fmt.Errorf("smtp failed after %d retries: %w", maxRetries, lastErr)
 }
 
 func SendErrorNotification(mainCaseNumber string,accountNamesList string) {
@@ -518,113 +595,49 @@ func SendErrorNotification(mainCaseNumber string,accountNamesList string) {
 
 	log.Printf("[%s] [EXCEPT] Data accountNames to send %v", mainCaseNumber, cleanedNames)
 
-	var bodyBuilder strings.Builder
-	bodyBuilder.WriteString("<p>เรียน ผู้ใช้งาน,</p><p>บริษัทฯ ขอแจ้งว่า <strong>ไม่สามารถส่งรายงานการโอนเงิน</strong> ของระบบ Online Payment Services (OPS)</p><p>โดยรายงานดังกล่าวเป็นรายงานการชำระเงินของบริษัทดังต่อไปนี้:</p><ol>")
-
-	for _, accountName := range cleanedNames {
-		bodyBuilder.WriteString(fmt.Sprintf("<li>%s</li>", accountName))
+	subject, body, text, err := renderMail(templateException, exceptionLang(), ExceptionData{
+		CaseNumber: mainCaseNumber,
+		Accounts:   cleanedNames,
+	})
+	if err != nil {
+		log.Printf("[%s] [EXCEPT] render error: %v", mainCaseNumber, err)
+		return
 	}
 
-	bodyBuilder.WriteString("</ol><p>ขอขอบคุณมา ณ ที่นี้</p><p>INET Online Payment Service</p>")
-	body := bodyBuilder.String()
-
 	errorToMail := os.Getenv("SMTP_SUPPORT")
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
+	smtpCfg := getMailer().Config()
 
 	if errorToMail == "" {
 		log.Printf("[%s] [EXCEPT] Invalid error_to_e-mail address is null or wrong format", mainCaseNumber)
 		return
 	}
 
-	subject := fmt.Sprintf("[Case No.[%s][EXCEPT] ส่งรายงานการรับเงินประจำวันไม่สำเร็จ", mainCaseNumber)
 	fromEmail := "no-reply@thaidotcompayment.co.th"
 	fromName := "INET Online Payment Service"
 	fromHeader := fmt.Sprintf("%s <%s>", fromName, fromEmail)
 
-	recipients := cleanEmail(errorToMail)
-
-	msg := []byte(
-		"Return-Path: " + fromEmail + "\r\n" +
-			"From: " + fromHeader + "\r\n" +
-			"To: " + strings.Join(recipients, ",") + "\r\n" +
-			"Subject: " + subject + "\r\n" +
-			"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
-			"Message-ID: <" + fmt.Sprintf("%d", time.Now().UnixNano()) + "@thaidotcompayment.co.th>\r\n" +
-			"MIME-Version: 1.0\r\n" +
-			"Content-Type: text/html; charset=UTF-8\r\n\r\n" +
-			body,
-	)
-
-	conn, err := net.Dial("tcp", smtpHost+":"+smtpPort)
-	if err != nil {
-		log.Printf("[%s] [EXCEPT] dial error: %v", mainCaseNumber, err)
-		return
+	recipients, rejected := parseRecipients(errorToMail)
+	for _, r := range rejected {
+		log.Printf("[%s] [EXCEPT] invalid SMTP_SUPPORT address %q: %s", mainCaseNumber, r.Address, r.Reason)
 	}
-
-	client, err := smtp.NewClient(conn, smtpHost)
-	if err != nil {
-		conn.Close()
-		log.Printf("[%s] [EXCEPT] client error: %v", mainCaseNumber, err)
-		return
-	}
-
-	if ok, _ := client.Extension("STARTTLS"); ok {
-		if err := client.StartTLS(&tls.Config{ServerName: smtpHost}); err != nil {
-			client.Quit()
-			conn.Close()
-			log.Printf("[%s] [EXCEPT] starttls error: %v", mainCaseNumber, err)
-			return
-		}
-	}
-
-	if err := client.Auth(smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)); err != nil {
-		client.Quit()
-		conn.Close()
-		log.Printf("[%s] [EXCEPT] auth error: %v", mainCaseNumber, err)
-		return
-	}
-
-	if err := client.Mail(fromEmail); err != nil {
-		client.Quit()
-		conn.Close()
-		log.Printf("[%s] [EXCEPT] mail from error: %v", mainCaseNumber, err)
+	if len(recipients) == 0 {
+		log.Printf("[%s] [EXCEPT] Invalid error_to_e-mail address is null or wrong format", mainCaseNumber)
 		return
 	}
 
-	for _, addr := range recipients {
-		if err := client.Rcpt(addr); err != nil {
-			client.Quit()
-			conn.Close()
-			log.Printf("[%s] [EXCEPT] rcpt error: %v", mainCaseNumber, err)
-			return
-		}
-	}
-
-	w, err := client.Data()
+	msg, err := buildMessage(fromEmail, fromHeader, recipients, subject, body, text)
 	if err != nil {
-		client.Quit()
-		conn.Close()
-		log.Printf("[%s] [EXCEPT] data error: %v", mainCaseNumber, err)
+		log.Printf("[%s] [EXCEPT] build message error: %v", mainCaseNumber, err)
 		return
 	}
 
-	if _, err := w.Write(msg); err != nil {
-		w.Close()
-		client.Quit()
-		conn.Close()
-		log.Printf("[%s] [EXCEPT] write error: %v", mainCaseNumber, err)
+	if err := getMailer().Send(fromEmail, recipients, msg); err != nil {
+		log.Printf("[%s] [EXCEPT] send error: %v", mainCaseNumber, err)
 		return
 	}
 
-	w.Close()
-	client.Quit()
-	conn.Close()
-
 	log.Printf("[%s] [EXCEPT] Message Data: From: %s, To: %s, Subject: %s", mainCaseNumber, fromEmail, strings.Join(recipients, ", "), subject)
-	log.Printf("[%s] [EXCEPT] SMTP Data: Host: %s, Port: %s, EnableSsl: false, From: %s, To: %s, Subject: %s", mainCaseNumber, smtpHost, smtpPort, fromEmail, strings.Join(recipients, ", "), subject)
+	log.Printf("[%s] [EXCEPT] SMTP Data: Host: %s, Port: %s, TLS: %s, From: %s, To: %s, Subject: %s", mainCaseNumber, smtpCfg.Host, smtpCfg.Port, smtpCfg.TLSMode, fromEmail, strings.Join(recipients, ", "), subject)
 	log.Printf("[%s] [EXCEPT] Notification email sent to admin successfully.", mainCaseNumber)
 	log.Printf("[%s] [EXCEPT] end of SendErrorNotification Function.", mainCaseNumber)
 }
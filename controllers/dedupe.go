@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"pond/database"
+	"pond/env"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// statusSkipped marks a detail that was not sent again because the same
+// report already went out or is being sent.
+const statusSkipped = "SKIPPED"
+
+// reportKey is what makes two details the same report.
+type reportKey struct {
+	TransferId  string
+	Type        string
+	PeriodStart string
+	PeriodEnd   string
+	RecipientId string
+}
+
+// newReportKey normalizes the period of d the way it is stored in
+// mail_outbox, so "" and today's date match.
+func newReportKey(reportType string, d DetailRes) reportKey {
+	k := reportKey{
+		TransferId:  d.TransferId,
+		Type:        reportType,
+		PeriodStart: d.StartDate,
+		PeriodEnd:   d.EndDate,
+		RecipientId: d.RecipientId,
+	}
+	if start, end, err := reportPeriod(d.StartDate, d.EndDate); err == nil {
+		k.PeriodStart = start.Format(reportDateLayout)
+		k.PeriodEnd = end.Format(reportDateLayout)
+	}
+	return k
+}
+
+func (k reportKey) where(db *gorm.DB) *gorm.DB {
+	return db.Where("transfer_id = ? AND type = ? AND period_start = ? AND period_end = ? AND recipient_id = ?",
+		k.TransferId, k.Type, k.PeriodStart, k.PeriodEnd, k.RecipientId)
+}
+
+// reservationTTL is how long a RESERVED report blocks others before it is
+// taken to belong to a request that died, from REPORT_RESERVATION_TTL
+// (default 6h).
+func reservationTTL() time.Duration {
+	return env.Duration("REPORT_RESERVATION_TTL", 6*time.Hour)
+}
+
+// reserveReports reserves the report of every detail that was not sent
+// and is not being sent already. It returns the details to send with their
+// index in details, and a SKIPPED result for every other one.
+func reserveReports(reportType string, details []DetailRes) ([]DetailRes, []int, []MailSendResult, error) {
+	var remaining []DetailRes
+	var kept []int
+	var skipped []MailSendResult
+	for i, d := range details {
+		k := newReportKey(reportType, d)
+		reason, err := reserveReport(k)
+		if err != nil {
+			releaseDetails(reportType, remaining)
+			return nil, nil, nil, err
+		}
+		if reason != "" {
+			skipped = append(skipped, MailSendResult{
+				TransferId: d.TransferId,
+				Status:     statusSkipped,
+				Error:      reason + "; set force to send again",
+				detail:     i,
+			})
+			continue
+		}
+		remaining = append(remaining, d)
+		kept = append(kept, i)
+	}
+	return remaining, kept, skipped, nil
+}
+
+// reserveReport returns why k cannot be sent now, or "" once it holds the
+// reservation for k.
+func reserveReport(k reportKey) (string, error) {
+	db := database.DBConn
+
+	// outbox ยังต้องเช็ค เพราะมีฉบับที่ส่งก่อนมีตารางนี้ และที่ส่งแบบ force/resend
+	var row database.MailOutbox
+	err := k.where(db).
+		Where("status IN ?", []string{database.OutboxPending, database.OutboxSending, database.OutboxSuccess}).
+		Order("id DESC").
+		First(&row).Error
+	if err == nil {
+		if row.Status == database.OutboxSuccess {
+			return "already sent at " + row.UpdatedAt.Format(time.RFC3339), nil
+		}
+		return "already being sent since " + row.CreatedAt.Format(time.RFC3339), nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	res := database.ReportDelivery{
+		TransferId:  k.TransferId,
+		Type:        k.Type,
+		PeriodStart: k.PeriodStart,
+		PeriodEnd:   k.PeriodEnd,
+		RecipientId: k.RecipientId,
+		Status:      database.ReportReserved,
+	}
+	err = db.Create(&res).Error
+	if err == nil {
+		return "", nil
+	}
+	if !database.IsDuplicateKey(err) {
+		return "", err
+	}
+
+	var prev database.ReportDelivery
+	if err := k.where(db).First(&prev).Error; err != nil {
+		return "", err
+	}
+	if prev.Status == database.ReportSent {
+		return "already sent at " + prev.UpdatedAt.Format(time.RFC3339), nil
+	}
+	if time.Since(prev.UpdatedAt) > reservationTTL() {
+		// จองค้างจาก request ที่ตายไป แย่งมาใช้ได้ถ้าไม่มีใครแย่งไปก่อน
+		taken := db.Model(&database.ReportDelivery{}).
+			Where("id = ? AND status = ? AND updated_at = ?", prev.ID, database.ReportReserved, prev.UpdatedAt).
+			Update("updated_at", time.Now())
+		if taken.Error != nil {
+			return "", taken.Error
+		}
+		if taken.RowsAffected == 1 {
+			log.Printf("%s [DEDUPE] took over reservation left since %s", k.TransferId, prev.UpdatedAt.Format(time.RFC3339))
+			return "", nil
+		}
+	}
+	return "already being sent since " + prev.UpdatedAt.Format(time.RFC3339), nil
+}
+
+// releaseReport drops the reservation of a report that could not be sent,
+// so a retry may send it.
+func releaseReport(k reportKey) {
+	err := k.where(database.DBConn).Where("status = ?", database.ReportReserved).Delete(&database.ReportDelivery{}).Error
+	if err != nil {
+		log.Printf("%s [DEDUPE] release error: %v", k.TransferId, err)
+	}
+}
+
+// markReportSent records k as sent, whether or not it was reserved first
+// (force and resend do not reserve).
+func markReportSent(k reportKey) {
+	err := database.DBConn.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"status", "updated_at"}),
+	}).Create(&database.ReportDelivery{
+		TransferId:  k.TransferId,
+		Type:        k.Type,
+		PeriodStart: k.PeriodStart,
+		PeriodEnd:   k.PeriodEnd,
+		RecipientId: k.RecipientId,
+		Status:      database.ReportSent,
+	}).Error
+	if err != nil {
+		log.Printf("%s [DEDUPE] mark sent error: %v", k.TransferId, err)
+	}
+}
+
+// releaseDetails releases the reservations reserveReports made for details.
+func releaseDetails(reportType string, details []DetailRes) {
+	for _, d := range details {
+		releaseReport(newReportKey(reportType, d))
+	}
+}
+
+// mergeSkipped puts the results of the details that were sent, whose
+// detail index points into kept, back in request order next to skipped.
+func mergeSkipped(skipped []MailSendResult, kept []int, results []MailSendResult) []MailSendResult {
+	merged := append([]MailSendResult{}, skipped...)
+	for _, r := range results {
+		r.detail = kept[r.detail]
+		merged = append(merged, r)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].detail < merged[j].detail
+	})
+	return merged
+}
+
+// keyOfOutbox is the report an outbox row was written for.
+func keyOfOutbox(row *database.MailOutbox) reportKey {
+	return reportKey{
+		TransferId:  row.TransferId,
+		Type:        row.Type,
+		PeriodStart: row.PeriodStart,
+		PeriodEnd:   row.PeriodEnd,
+		RecipientId: row.RecipientId,
+	}
+}
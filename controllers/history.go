@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pond/apikey"
+	"pond/database"
+	"pond/env"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize  = 50
+	maxPageSize      = 500
+	defaultExportMax = 10000
+)
+
+// DeliveryRecord is a MailSendResult as stored in mail_outbox, with its
+// bookkeeping.
+type DeliveryRecord struct {
+	MailSendResult
+	ID          uint      `json:"id"`
+	JobId       string    `json:"job_id,omitempty"`
+	Type        string    `json:"type"`
+	PeriodStart string    `json:"period_start"`
+	PeriodEnd   string    `json:"period_end"`
+	Attempts    int       `json:"attempts"`
+	SmtpReply   string    `json:"smtp_response"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func deliveryRecord(row *database.MailOutbox) DeliveryRecord {
+	return DeliveryRecord{
+		MailSendResult: resultFromOutbox(row),
+		ID:             row.ID,
+		JobId:          row.JobId,
+		Type:           row.Type,
+		PeriodStart:    row.PeriodStart,
+		PeriodEnd:      row.PeriodEnd,
+		Attempts:       row.Attempts,
+		SmtpReply:      row.SmtpReply,
+		CreatedAt:      row.CreatedAt,
+		UpdatedAt:      row.UpdatedAt,
+	}
+}
+
+// deliveryQuery applies the query string filters shared by the search and
+// the export: transfer_id, corporation, email, status, type and from/to
+// (YYYY-MM-DD, both inclusive, on the time the mail was written). A client
+// only sees the types it may send.
+func deliveryQuery(c *fiber.Ctx, client *apikey.Client) (*gorm.DB, error) {
+	q := database.DBConn.Model(&database.MailOutbox{})
+
+	if v := c.Query("transfer_id"); v != "" {
+		q = q.Where("transfer_id = ?", v)
+	}
+	if v := c.Query("corporation"); v != "" {
+		q = q.Where("corporation LIKE ?", "%"+escapeLike(v)+"%")
+	}
+	if v := c.Query("email"); v != "" {
+		q = q.Where("recipients LIKE ?", "%"+escapeLike(v)+"%")
+	}
+	if v := c.Query("status"); v != "" {
+		v = strings.ToUpper(v)
+		switch v {
+		case database.OutboxPending, database.OutboxSending, database.OutboxSuccess, database.OutboxFail:
+		default:
+			return nil, fmt.Errorf("invalid status %q", v)
+		}
+		q = q.Where("status = ?", v)
+	}
+	if v := c.Query("type"); v != "" {
+		if !client.Allows(v) {
+			return nil, fmt.Errorf("type %s not allowed for this client", v)
+		}
+		q = q.Where("type = ?", v)
+	} else {
+		q = q.Where("type IN ?", client.AllowedTypes)
+	}
+	if v := c.Query("from"); v != "" {
+		from, err := time.ParseInLocation(reportDateLayout, v, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from %q, expected YYYY-MM-DD", v)
+		}
+		q = q.Where("created_at >= ?", from)
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := time.ParseInLocation(reportDateLayout, v, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to %q, expected YYYY-MM-DD", v)
+		}
+		q = q.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+	return q, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchDeliveries serves GET /SMTP/deliveries, newest first, paged with
+// page and page_size.
+func SearchDeliveries(c *fiber.Ctx) error {
+	client := c.Locals(apiClientLocal).(*apikey.Client)
+	q, err := deliveryQuery(c, client)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid filter",
+			"detail": err.Error(),
+		})
+	}
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	if page < 1 {
+		page = 1
+	}
+	size, _ := strconv.Atoi(c.Query("page_size"))
+	if size < 1 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	var total int64
+	if err := q.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":  "Failed to count deliveries",
+			"detail": err.Error(),
+		})
+	}
+
+	var rows []database.MailOutbox
+	if err := q.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&rows).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":  "Failed to load deliveries",
+			"detail": err.Error(),
+		})
+	}
+
+	records := make([]DeliveryRecord, 0, len(rows))
+	for i := range rows {
+		records = append(records, deliveryRecord(&rows[i]))
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"responseCode": "00",
+		"page":         page,
+		"page_size":    size,
+		"total":        total,
+		"results":      records,
+	})
+}
+
+// exportMax caps the rows of one CSV export, from DELIVERY_EXPORT_MAX
+// (default 10000).
+func exportMax() int {
+	return env.Int("DELIVERY_EXPORT_MAX", defaultExportMax)
+}
+
+// ExportDeliveries serves GET /SMTP/deliveries/export, the same search as
+// CSV without paging.
+func ExportDeliveries(c *fiber.Ctx) error {
+	client := c.Locals(apiClientLocal).(*apikey.Client)
+	q, err := deliveryQuery(c, client)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid filter",
+			"detail": err.Error(),
+		})
+	}
+
+	var rows []database.MailOutbox
+	if err := q.Order("id DESC").Limit(exportMax()).Find(&rows).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":  "Failed to load deliveries",
+			"detail": err.Error(),
+		})
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{
+		"id", "job_id", "transfer_id", "type", "period_start", "period_end",
+		"corporation_name", "corporation_email", "receiver_email", "status", "stage", "error",
+		"attempts", "smtp_response", "short_link", "full_link", "created_at", "updated_at",
+	})
+	for i := range rows {
+		r := deliveryRecord(&rows[i])
+		w.Write([]string{
+			strconv.FormatUint(uint64(r.ID), 10), csvCell(r.JobId), csvCell(r.TransferId),
+			csvCell(r.Type), csvCell(r.PeriodStart), csvCell(r.PeriodEnd),
+			csvCell(r.Corporation), csvCell(r.Corpemail), csvCell(r.Email),
+			csvCell(r.Status), csvCell(r.Stage), csvCell(r.Error),
+			strconv.Itoa(r.Attempts), csvCell(r.SmtpReply), csvCell(r.ShortLink), csvCell(r.FullLink),
+			r.CreatedAt.Format(time.RFC3339), r.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":  "Failed to write CSV",
+			"detail": err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="deliveries-%s.csv"`, time.Now().Format("20060102-150405")))
+	return c.Status(200).Send(buf.Bytes())
+}
+
+// csvCell stops a spreadsheet from running a value as a formula when the
+// export is opened, by prefixing values that start like one with '.
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
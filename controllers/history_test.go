@@ -0,0 +1,21 @@
+package controllers
+
+import "testing"
+
+func TestCSVCell(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"", ""},
+		{"ACME Co., Ltd.", "ACME Co., Ltd."},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+66 2 000 0000", "'+66 2 000 0000"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"a=1", "a=1"},
+	}
+	for _, c := range cases {
+		if got := csvCell(c.in); got != c.want {
+			t.Errorf("csvCell(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
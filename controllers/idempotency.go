@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"pond/database"
+	"pond/env"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotencyTTL is how long a stored response is replayed, from
+// IDEMPOTENCY_TTL (default 24h).
+func idempotencyTTL() time.Duration {
+	return env.Duration("IDEMPOTENCY_TTL", 24*time.Hour)
+}
+
+// claimIdempotencyKey reserves the Idempotency-Key header of c for client.
+// It returns nil when there is no header. When the key was seen before, the
+// stored response (or an error) is written to c and replied is true.
+func claimIdempotencyKey(c *fiber.Ctx, client string) (row *database.IdempotencyKey, replied bool, err error) {
+	key := c.Get(idempotencyHeader)
+	if key == "" {
+		return nil, false, nil
+	}
+	if len(key) > 128 {
+		return nil, true, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Idempotency-Key is longer than 128 characters",
+		})
+	}
+
+	sum := sha256.Sum256(c.Body())
+	hash := hex.EncodeToString(sum[:])
+	db := database.DBConn
+
+	for retry := 0; ; retry++ {
+		row = &database.IdempotencyKey{
+			ClientName:  client,
+			Key:         key,
+			RequestHash: hash,
+			Status:      database.IdempotencyRunning,
+		}
+		err = db.Create(row).Error
+		if err == nil {
+			return row, false, nil
+		}
+		if !database.IsDuplicateKey(err) || retry > 0 {
+			break
+		}
+
+		var prev database.IdempotencyKey
+		if err = db.First(&prev, "client_name = ? AND `key` = ?", client, key).Error; err != nil {
+			break
+		}
+		if time.Since(prev.CreatedAt) > idempotencyTTL() {
+			// หมดอายุแล้ว ลบทิ้งแล้วใช้ key นี้ใหม่
+			db.Where("client_name = ? AND `key` = ? AND created_at = ?", client, key, prev.CreatedAt).Delete(&database.IdempotencyKey{})
+			continue
+		}
+		if prev.RequestHash != hash {
+			return nil, true, c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Idempotency-Key was already used for a different request",
+			})
+		}
+		if prev.Status != database.IdempotencyDone {
+			return nil, true, c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "A request with this Idempotency-Key is still in progress",
+			})
+		}
+
+		log.Printf("[API] replaying response of Idempotency-Key %q for client %s", key, client)
+		c.Set("Idempotent-Replayed", "true")
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return nil, true, c.Status(prev.StatusCode).SendString(prev.Response)
+	}
+
+	log.Printf("[API] idempotency key error: %v", err)
+	return nil, true, c.Status(500).JSON(fiber.Map{
+		"error":  "Cannot store Idempotency-Key",
+		"detail": err.Error(),
+	})
+}
+
+// finishIdempotencyKey stores the response sent for row. A server error
+// releases the key instead so the caller can retry with it.
+func finishIdempotencyKey(row *database.IdempotencyKey, status int, body fiber.Map) {
+	if row == nil {
+		return
+	}
+	db := database.DBConn
+
+	if status >= 500 {
+		if err := db.Delete(row).Error; err != nil {
+			log.Printf("[API] release Idempotency-Key %q error: %v", row.Key, err)
+		}
+		return
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("[API] encode response for Idempotency-Key %q error: %v", row.Key, err)
+		return
+	}
+	row.Status = database.IdempotencyDone
+	row.StatusCode = status
+	row.Response = string(raw)
+	if err := db.Save(row).Error; err != nil {
+		log.Printf("[API] store Idempotency-Key %q error: %v", row.Key, err)
+	}
+}
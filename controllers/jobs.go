@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"pond/apikey"
+	"pond/database"
+	"pond/env"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	jobQueue     chan string
+	jobQueueOnce sync.Once
+)
+
+// errJobQueueFull is returned by submitJob when every queue slot is taken.
+var errJobQueueFull = errors.New("job queue is full")
+
+// startJobWorkers lazily starts JOB_WORKERS goroutines (default 1) that run
+// queued jobs one at a time each.
+func startJobWorkers() {
+	jobQueueOnce.Do(func() {
+		workers := env.Int("JOB_WORKERS", 1)
+		jobQueue = make(chan string, 1024)
+		for i := 0; i < workers; i++ {
+			go func() {
+				for id := range jobQueue {
+					runJob(id)
+				}
+			}()
+		}
+	})
+}
+
+func submitJob(req ReceiveResFormat, clientName string) (*database.MailJob, error) {
+	req.Key = "" // ไม่เก็บ key ลง db
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	job := database.MailJob{
+		ID:         uuid.NewString(),
+		Type:       req.Type,
+		ClientName: clientName,
+		Status:     database.JobQueued,
+		Total:      len(req.Detail),
+		Request:    string(raw),
+	}
+	if err := database.DBConn.Create(&job).Error; err != nil {
+		return nil, err
+	}
+
+	startJobWorkers()
+	select {
+	case jobQueue <- job.ID:
+	default:
+		// คิวเต็ม ไม่ให้ handler ค้าง ลบงานทิ้งแล้วให้ caller ลองใหม่
+		if err := database.DBConn.Delete(&job).Error; err != nil {
+			log.Printf("[JOB %s] delete unqueued job error: %v", job.ID, err)
+		}
+		return nil, errJobQueueFull
+	}
+	log.Printf("[JOB %s] queued %s with %d details", job.ID, job.Type, job.Total)
+	return &job, nil
+}
+
+func runJob(id string) {
+	db := database.DBConn
+
+	var job database.MailJob
+	if err := db.First(&job, "id = ?", id).Error; err != nil {
+		log.Printf("[JOB %s] load error: %v", id, err)
+		return
+	}
+
+	var req ReceiveResFormat
+	err := json.Unmarshal([]byte(job.Request), &req)
+	if err != nil {
+		finishJob(&job, err)
+		return
+	}
+
+	job.Status = database.JobRunning
+	if err := db.Save(&job).Error; err != nil {
+		log.Printf("[JOB %s] update error: %v", id, err)
+	}
+
+	// job ที่ค้างจากรอบก่อน ส่งเฉพาะรายการที่ยังไม่มีใน outbox
+	req.Detail, err = pendingDetails(job.ID, req.Detail)
+	if err != nil {
+		finishJob(&job, err)
+		return
+	}
+	if len(req.Detail) == 0 {
+		finishJob(&job, nil)
+		return
+	}
+
+	urlResults, tokenFailures, err := GenToken(context.Background(), req)
+	if err != nil {
+		if !req.Force {
+			releaseDetails(req.Type, req.Detail)
+		}
+		finishJob(&job, err)
+		return
+	}
+
+	processMailSend(job.ID, urlResults, tokenFailures, !req.Force)
+	finishJob(&job, nil)
+}
+
+// pendingDetails drops the details that already have an outbox row in the
+// job, i.e. were handled before the process stopped.
+func pendingDetails(jobId string, details []DetailRes) ([]DetailRes, error) {
+	var rows []database.MailOutbox
+	err := database.DBConn.Select("transfer_id", "recipient_id").Where("job_id = ?", jobId).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	done := map[[2]string]bool{}
+	for _, r := range rows {
+		done[[2]string{r.TransferId, r.RecipientId}] = true
+	}
+
+	var pending []DetailRes
+	for _, d := range details {
+		if !done[[2]string{d.TransferId, d.RecipientId}] {
+			pending = append(pending, d)
+		}
+	}
+	return pending, nil
+}
+
+func finishJob(job *database.MailJob, err error) {
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = database.JobFailed
+		job.Error = err.Error()
+		log.Printf("[JOB %s] failed: %v", job.ID, err)
+	} else {
+		job.Status = database.JobDone
+		log.Printf("[JOB %s] done", job.ID)
+	}
+	if dbErr := database.DBConn.Save(job).Error; dbErr != nil {
+		log.Printf("[JOB %s] update error: %v", job.ID, dbErr)
+	}
+}
+
+// ResumeJobs re-queues jobs created before startedAt that were waiting or
+// running when the process stopped. A running job only gets its details
+// that never reached the outbox; emails already there are picked up by
+// ResumeOutbox.
+func ResumeJobs(startedAt time.Time) {
+	db := database.DBConn
+
+	var ids []string
+	err := db.Model(&database.MailJob{}).
+		Where("status IN ?", []string{database.JobQueued, database.JobRunning}).
+		Where("created_at < ?", startedAt).
+		Order("created_at").
+		Pluck("id", &ids).Error
+	if err != nil {
+		log.Printf("[JOB] resume query error: %v", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	startJobWorkers()
+	log.Printf("[JOB] re-queueing %d jobs", len(ids))
+	for _, id := range ids {
+		jobQueue <- id
+	}
+}
+
+func GetJob(c *fiber.Ctx) error {
+	db := database.DBConn
+
+	var job database.MailJob
+	err := db.First(&job, "id = ?", c.Params("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":  "Failed to load job",
+			"detail": err.Error(),
+		})
+	}
+	client := c.Locals(apiClientLocal).(*apikey.Client)
+	if !jobOwnedBy(&job, client) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+	if !client.Allows(job.Type) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Type not allowed for this client",
+		})
+	}
+
+	var rows []database.MailOutbox
+	if err := db.Where("job_id = ?", job.ID).Order("id").Find(&rows).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":  "Failed to load job results",
+			"detail": err.Error(),
+		})
+	}
+
+	results := make([]MailSendResult, 0, len(rows))
+	for i := range rows {
+		results = append(results, resultFromOutbox(&rows[i]))
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"responseCode": "00",
+		"job_id":       job.ID,
+		"type":         job.Type,
+		"status":       job.Status,
+		"error":        job.Error,
+		"created_at":   job.CreatedAt,
+		"finished_at":  job.FinishedAt,
+		"summary":      summarize(job.Total, results),
+		"results":      results,
+	})
+}
+
+// jobOwnedBy reports whether client submitted job. Jobs stored before
+// client_name existed have no owner and are left to the type check.
+func jobOwnedBy(job *database.MailJob, client *apikey.Client) bool {
+	return job.ClientName == "" || job.ClientName == client.Name
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"pond/database"
+)
+
+// enqueueOutbox stores the rendered payload as PENDING before it is sent.
+func enqueueOutbox(jobId string, p MailPayload) (*database.MailOutbox, error) {
+	row := database.MailOutbox{
+		JobId:       jobId,
+		TransferId:  p.TransferId,
+		FromHeader:  p.FromHeader,
+		Recipients:  strings.Join(p.To, ","),
+		Bcc:         strings.Join(p.Bcc, ","),
+		Subject:     p.Subject,
+		Body:        p.Body,
+		TextBody:    p.TextBody,
+		Type:        p.Type,
+		PeriodStart: p.StartDate,
+		PeriodEnd:   p.EndDate,
+		RecipientId: p.RecipientId,
+		ShortLink:   p.ShortLink,
+		FullLink:    p.FullLink,
+		Corporation: strings.Join(p.Corporation, ","),
+		Corpemail:   strings.Join(p.Corpemail, ","),
+		AttachPdf:   p.AttachPdf,
+		Reserved:    p.Reserved,
+		Status:      database.OutboxPending,
+	}
+	if err := database.DBConn.Create(&row).Error; err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// recordRejected stores each invalid recipient of p as its own FAIL row so
+// it shows up in job results next to the transfer's delivery.
+func recordRejected(jobId string, p MailPayload) []MailSendResult {
+	results := rejectedResults(p)
+	for _, r := range results {
+		row := database.MailOutbox{
+			JobId:       jobId,
+			TransferId:  r.TransferId,
+			FromHeader:  p.FromHeader,
+			Recipients:  r.Email,
+			Subject:     p.Subject,
+			Type:        p.Type,
+			PeriodStart: p.StartDate,
+			PeriodEnd:   p.EndDate,
+			RecipientId: p.RecipientId,
+			ShortLink:   r.ShortLink,
+			FullLink:    r.FullLink,
+			Corporation: r.Corporation,
+			Corpemail:   r.Corpemail,
+			Status:      database.OutboxFail,
+			LastError:   r.Error,
+		}
+		if err := database.DBConn.Create(&row).Error; err != nil {
+			log.Printf("%s [OUTBOX] record rejected %q error: %v", r.TransferId, r.Email, err)
+		}
+	}
+	return results
+}
+
+// recordFailure stores a transfer that failed before a mail could be built,
+// e.g. at the token stage, and releases the report reservation it holds.
+func recordFailure(jobId string, r MailSendResult) {
+	if r.reserved {
+		releaseReport(r.report)
+	}
+	row := database.MailOutbox{
+		JobId:       jobId,
+		TransferId:  r.TransferId,
+		Recipients:  r.Email,
+		Type:        r.report.Type,
+		PeriodStart: r.report.PeriodStart,
+		PeriodEnd:   r.report.PeriodEnd,
+		RecipientId: r.report.RecipientId,
+		ShortLink:   r.ShortLink,
+		FullLink:    r.FullLink,
+		Corporation: r.Corporation,
+		Corpemail:   r.Corpemail,
+		Status:      database.OutboxFail,
+		Stage:       r.Stage,
+		LastError:   r.Error,
+	}
+	if err := database.DBConn.Create(&row).Error; err != nil {
+		log.Printf("%s [OUTBOX] record failure error: %v", r.TransferId, err)
+	}
+}
+
+func payloadFromOutbox(row *database.MailOutbox) MailPayload {
+	return MailPayload{
+		FromHeader:  row.FromHeader,
+		Subject:     row.Subject,
+		Body:        row.Body,
+		TextBody:    row.TextBody,
+		Type:        row.Type,
+		StartDate:   row.PeriodStart,
+		EndDate:     row.PeriodEnd,
+		RecipientId: row.RecipientId,
+		To:          cleanEmail(row.Recipients),
+		Bcc:         cleanEmail(row.Bcc),
+		ShortLink:   row.ShortLink,
+		FullLink:    row.FullLink,
+		TransferId:  row.TransferId,
+		Corporation: cleanEmail(row.Corporation),
+		Corpemail:   cleanEmail(row.Corpemail),
+		AttachPdf:   row.AttachPdf,
+	}
+}
+
+func resultFromOutbox(row *database.MailOutbox) MailSendResult {
+	return MailSendResult{
+		TransferId:  row.TransferId,
+		Email:       row.Recipients,
+		ShortLink:   row.ShortLink,
+		FullLink:    row.FullLink,
+		Status:      row.Status,
+		Stage:       row.Stage,
+		Error:       row.LastError,
+		Corporation: row.Corporation,
+		Corpemail:   row.Corpemail,
+	}
+}
+
+// deliverOutbox sends one outbox row and records the outcome on it.
+func deliverOutbox(row *database.MailOutbox) MailSendResult {
+	db := database.DBConn
+
+	row.Status = database.OutboxSending
+	row.Attempts++
+	if err := db.Save(row).Error; err != nil {
+		log.Printf("%s [OUTBOX] update row %d error: %v", row.TransferId, row.ID, err)
+	}
+
+	p := payloadFromOutbox(row)
+	var err error
+	if len(p.To) == 0 || p.To[0] == "" {
+		err = fmt.Errorf("no valid recipient email found for transfer_id: %s", row.TransferId)
+	} else {
+		row.SmtpReply, err = sendMail(p)
+	}
+
+	if err != nil {
+		row.Status = database.OutboxFail
+		row.LastError = err.Error()
+	} else {
+		row.Status = database.OutboxSuccess
+		row.LastError = ""
+	}
+	// force/resend/ไม่ได้จองไว้ ห้ามปล่อยการจองของ request อื่น
+	if err == nil && row.Type != "" {
+		markReportSent(keyOfOutbox(row))
+	} else if err != nil && row.Reserved {
+		releaseReport(keyOfOutbox(row))
+	}
+	if err := db.Save(row).Error; err != nil {
+		log.Printf("%s [OUTBOX] update row %d error: %v", row.TransferId, row.ID, err)
+	}
+
+	return resultFromOutbox(row)
+}
+
+// ResumeOutbox sends rows left PENDING or SENDING by a previous run that
+// stopped mid-batch. Only rows written before startedAt are taken, so mail
+// queued by requests this run is already serving is not sent twice.
+func ResumeOutbox(startedAt time.Time) {
+	var rows []database.MailOutbox
+	err := database.DBConn.
+		Where("status IN ?", []string{database.OutboxPending, database.OutboxSending}).
+		Where("created_at < ?", startedAt).
+		Order("id").
+		Find(&rows).Error
+	if err != nil {
+		log.Printf("[OUTBOX] resume query error: %v", err)
+		return
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	log.Printf("[OUTBOX] resuming %d unsent emails", len(rows))
+	for i := range rows {
+		r := deliverOutbox(&rows[i])
+		log.Printf("%s [OUTBOX] resumed row %d: %s", r.TransferId, rows[i].ID, r.Status)
+	}
+}
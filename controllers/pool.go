@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"sync"
+)
+
+// runPool calls fn for every index in [0, n) on at most workers goroutines
+// and waits for all of them. Callers write results into a slice by index so
+// the output keeps the input order.
+func runPool(workers, n int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"pond/env"
+	"pond/format"
+	"pond/repository"
+)
+
+const (
+	reportDateLayout   = "2006-01-02"
+	defaultMaxSpanDays = 31
+)
+
+// reportPeriod parses the start_date/end_date of a detail. A missing date
+// takes the other one, and no dates at all means today.
+func reportPeriod(startDate, endDate string) (time.Time, time.Time, error) {
+	if startDate == "" {
+		startDate = endDate
+	}
+	if endDate == "" {
+		endDate = startDate
+	}
+	if startDate == "" {
+		today := time.Now().Format(reportDateLayout)
+		startDate, endDate = today, today
+	}
+
+	start, err := time.ParseInLocation(reportDateLayout, startDate, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start_date %q, expected YYYY-MM-DD", startDate)
+	}
+	end, err := time.ParseInLocation(reportDateLayout, endDate, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end_date %q, expected YYYY-MM-DD", endDate)
+	}
+	return start, end, nil
+}
+
+func maxSpanDays() int {
+	return env.Int("REPORT_MAX_SPAN_DAYS", defaultMaxSpanDays)
+}
+
+// validatePeriod checks the date format, that start is not after end and
+// that the period is at most REPORT_MAX_SPAN_DAYS days (both ends counted).
+func validatePeriod(startDate, endDate string) error {
+	start, end, err := reportPeriod(startDate, endDate)
+	if err != nil {
+		return err
+	}
+	if start.After(end) {
+		return fmt.Errorf("start_date %s is after end_date %s", startDate, endDate)
+	}
+	if days := int(end.Sub(start).Hours()/24) + 1; days > maxSpanDays() {
+		return fmt.Errorf("period of %d days exceeds the maximum of %d", days, maxSpanDays())
+	}
+	return nil
+}
+
+func validateDetails(details []DetailRes) error {
+	for i, d := range details {
+		if err := validatePeriod(d.StartDate, d.EndDate); err != nil {
+			return fmt.Errorf("details[%d] (%s): %w", i, d.TransferId, err)
+		}
+	}
+	return nil
+}
+
+// reportData formats the figures in m for the corporation's locale.
+func reportData(m MailDetail, loc format.Locale) (ReportData, error) {
+	start, end, err := reportPeriod(m.MinDateTime, m.MaxDateTime)
+	if err != nil {
+		return ReportData{}, err
+	}
+	count, err := strconv.ParseInt(m.SumTxnCount, 10, 64)
+	if err != nil {
+		return ReportData{}, fmt.Errorf("invalid transaction count %q", m.SumTxnCount)
+	}
+	amount, err := format.ParseDecimal(m.SumTxnAmount)
+	if err != nil {
+		return ReportData{}, err
+	}
+
+	return ReportData{
+		Corporation: m.AccountName,
+		PeriodStart: loc.Date(start),
+		PeriodEnd:   loc.Date(end),
+		TxnCount:    loc.Count(count),
+		TxnAmount:   loc.Amount(amount),
+	}, nil
+}
+
+// loadMailDetail fills m with the transaction figures of transferId for the
+// requested period (both dates inclusive).
+func loadMailDetail(m *MailDetail, transferId, accountName, startDate, endDate string) error {
+	start, end, err := reportPeriod(startDate, endDate)
+	if err != nil {
+		return err
+	}
+
+	sum, err := repository.SummarizeTransactions(transferId, start, end.AddDate(0, 0, 1))
+	if err != nil {
+		return fmt.Errorf("summarize transactions: %w", err)
+	}
+
+	m.AccountName = accountName
+	m.MinDateTime = start.Format(reportDateLayout)
+	m.MaxDateTime = end.Format(reportDateLayout)
+	m.SumTxnCount = strconv.FormatInt(sum.TxnCount, 10)
+	m.SumTxnAmount = sum.TxnAmount
+	return nil
+}
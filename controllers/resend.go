@@ -0,0 +1,357 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"pond/apikey"
+	"pond/database"
+	"pond/env"
+	"pond/shortlink"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type ResendRequest struct {
+	TransferIds []string `json:"transferIds"`
+	JobId       string   `json:"jobId"`
+	OnlyFailed  bool     `json:"onlyFailed"`
+	Recipients  []string `json:"recipients"` // ถ้าส่งมาจะใช้แทนผู้รับเดิมทั้งหมด
+}
+
+// resendItem is one report to send again. prev is the last mail rendered
+// for it, nil when it never got that far (e.g. the token failed). pos is its
+// place in the request, results are returned in that order.
+type resendItem struct {
+	reportType string
+	detail     DetailRes
+	prev       *database.MailOutbox
+	pos        int
+}
+
+// ResendMail serves POST /SMTP/resend. It sends the reports of transferIds,
+// or of an earlier async job, again. Mails whose token and short link still
+// work are resent as stored; the rest get a new token and link first.
+func ResendMail(c *fiber.Ctx) error {
+	var req ResendRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Cannot parse JSON",
+			"detail": err.Error(),
+		})
+	}
+	if len(req.TransferIds) == 0 && req.JobId == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"detail": "set transferIds or jobId",
+		})
+	}
+
+	var override []string
+	if len(req.Recipients) > 0 {
+		to, rejected := parseRecipients(strings.Join(req.Recipients, ","))
+		if len(rejected) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":  "Invalid recipients",
+				"detail": rejected[0].Address + ": " + rejected[0].Reason,
+			})
+		}
+		override = to
+	}
+
+	client := c.Locals(apiClientLocal).(*apikey.Client)
+	idem, replied, err := claimIdempotencyKey(c, client.Name)
+	if replied {
+		return err
+	}
+	reply := func(status int, body fiber.Map) error {
+		finishIdempotencyKey(idem, status, body)
+		return c.Status(status).JSON(body)
+	}
+
+	var items []resendItem
+	var results []MailSendResult
+	if req.JobId != "" {
+		items, err = resendItemsFromJob(req.JobId, client, req.TransferIds)
+	} else {
+		items, results, err = resendItemsFromTransfers(req.TransferIds)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return reply(fiber.StatusNotFound, fiber.Map{
+			"error": "Job not found",
+		})
+	}
+	if err != nil {
+		return reply(500, fiber.Map{
+			"error":  "Failed to load previous deliveries",
+			"detail": err.Error(),
+		})
+	}
+
+	var todo []resendItem
+	for _, it := range items {
+		if !client.Allows(it.reportType) {
+			results = append(results, MailSendResult{
+				TransferId: it.detail.TransferId,
+				Status:     "FAIL",
+				Error:      "type " + it.reportType + " not allowed for this client",
+				detail:     it.pos,
+			})
+			continue
+		}
+		if req.OnlyFailed && it.prev != nil && it.prev.Status == database.OutboxSuccess {
+			continue
+		}
+		todo = append(todo, it)
+	}
+	log.Printf("[RESEND] client %s resending %d reports", client.Name, len(todo))
+
+	results = append(results, resend(c.UserContext(), todo, override)...)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].detail < results[j].detail
+	})
+
+	return reply(200, fiber.Map{
+		"responseCode": "00",
+		"summary":      summarize(len(results), results),
+		"results":      results,
+	})
+}
+
+// resendItemsFromJob lists the details of an async job, or only those in
+// transferIds when it is not empty. A job submitted by another client is
+// reported as not found.
+func resendItemsFromJob(jobId string, client *apikey.Client, transferIds []string) ([]resendItem, error) {
+	var job database.MailJob
+	if err := database.DBConn.First(&job, "id = ?", jobId).Error; err != nil {
+		return nil, err
+	}
+	if !jobOwnedBy(&job, client) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var req ReceiveResFormat
+	if err := json.Unmarshal([]byte(job.Request), &req); err != nil {
+		return nil, err
+	}
+
+	want := map[string]bool{}
+	for _, id := range transferIds {
+		want[id] = true
+	}
+
+	var items []resendItem
+	for _, d := range req.Detail {
+		if len(want) > 0 && !want[d.TransferId] {
+			continue
+		}
+		prev, err := lastRenderedMail(req.Type, d)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, resendItem{reportType: req.Type, detail: d, prev: prev, pos: len(items)})
+	}
+	return items, nil
+}
+
+// resendItemsFromTransfers takes the last mail rendered for every report
+// (type, period and recipient) of each transfer. A transfer that never got
+// one comes back as a FAIL result.
+func resendItemsFromTransfers(transferIds []string) ([]resendItem, []MailSendResult, error) {
+	db := database.DBConn
+	var items []resendItem
+	var failed []MailSendResult
+	pos := 0
+	for _, id := range transferIds {
+		var ids []uint
+		err := db.Model(&database.MailOutbox{}).
+			Where("transfer_id = ? AND body <> ''", id).
+			Group("type, period_start, period_end, recipient_id").
+			Pluck("MAX(id)", &ids).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(ids) == 0 {
+			failed = append(failed, MailSendResult{
+				TransferId: id,
+				Status:     "FAIL",
+				Error:      "no earlier report to resend for this transfer",
+				detail:     pos,
+			})
+			pos++
+			continue
+		}
+
+		var rows []database.MailOutbox
+		if err := db.Where("id IN ?", ids).Order("id").Find(&rows).Error; err != nil {
+			return nil, nil, err
+		}
+		for i := range rows {
+			row := &rows[i]
+			items = append(items, resendItem{
+				reportType: row.Type,
+				detail: DetailRes{
+					TransferId:  row.TransferId,
+					RecipientId: row.RecipientId,
+					StartDate:   row.PeriodStart,
+					EndDate:     row.PeriodEnd,
+				},
+				prev: row,
+				pos:  pos,
+			})
+			pos++
+		}
+	}
+	return items, failed, nil
+}
+
+// lastRenderedMail returns the newest mail rendered for the report of d, or
+// nil if there is none.
+func lastRenderedMail(reportType string, d DetailRes) (*database.MailOutbox, error) {
+	start, end, err := reportPeriod(d.StartDate, d.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	var row database.MailOutbox
+	err = database.DBConn.
+		Where("transfer_id = ? AND type = ? AND period_start = ? AND period_end = ? AND recipient_id = ?",
+			d.TransferId, reportType, start.Format(reportDateLayout), end.Format(reportDateLayout), d.RecipientId).
+		Where("body <> ''").
+		Order("id DESC").
+		First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// resend sends items again. Each result's detail is the pos of its item.
+func resend(ctx context.Context, items []resendItem, override []string) []MailSendResult {
+	var stored []*database.MailOutbox
+	var storedPos []int
+	var types []string
+	regen := map[string][]DetailRes{}
+	regenPos := map[string][]int{}
+	for _, it := range items {
+		if it.prev != nil && reusableLink(ctx, it.prev) {
+			stored = append(stored, it.prev)
+			storedPos = append(storedPos, it.pos)
+			continue
+		}
+		if _, ok := regen[it.reportType]; !ok {
+			types = append(types, it.reportType)
+		}
+		regen[it.reportType] = append(regen[it.reportType], it.detail)
+		regenPos[it.reportType] = append(regenPos[it.reportType], it.pos)
+	}
+
+	results := resendStored(stored, override)
+	for i := range results {
+		results[i].detail = storedPos[i]
+	}
+
+	for _, t := range types {
+		urlResults, tokenFailures, err := GenToken(ctx, ReceiveResFormat{Type: t, Detail: regen[t]})
+		if err != nil {
+			for i, d := range regen[t] {
+				results = append(results, MailSendResult{
+					TransferId: d.TransferId,
+					Status:     "FAIL",
+					Stage:      stageToken,
+					Error:      err.Error(),
+					detail:     regenPos[t][i],
+				})
+			}
+			continue
+		}
+		if override != nil {
+			for i := range urlResults {
+				urlResults[i].Corpemail = strings.Join(override, ",")
+			}
+		}
+		for _, r := range processMailSend("", urlResults, tokenFailures, false) {
+			r.detail = regenPos[t][r.detail]
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// resendStored sends already rendered mails again as new outbox rows.
+func resendStored(rows []*database.MailOutbox, override []string) []MailSendResult {
+	results := make([]MailSendResult, len(rows))
+	runPool(env.Int("SMTP_WORKERS", 2), len(rows), func(i int) {
+		p := payloadFromOutbox(rows[i])
+		if override != nil {
+			p.To = override
+		}
+		row, err := enqueueOutbox("", p)
+		if err != nil {
+			log.Printf("%s [OUTBOX] enqueue error: %v", p.TransferId, err)
+			results[i] = resultFromOutbox(rows[i])
+			results[i].Status = "FAIL"
+			results[i].Error = "cannot write mail to outbox: " + err.Error()
+			return
+		}
+		log.Printf("%s [RESEND] reusing links of row %d", p.TransferId, rows[i].ID)
+		results[i] = deliverOutbox(row)
+	})
+
+	var failedAccounts []string
+	for _, r := range results {
+		if r.Status != "SUCCESS" {
+			failedAccounts = append(failedAccounts, r.Corporation)
+		}
+	}
+	if len(failedAccounts) > 0 {
+		SendErrorNotification(generateCaseNumber(), strings.Join(failedAccounts, "\n"))
+	}
+	return results
+}
+
+// resendReuseMaxAge is how long a token from the token service is assumed
+// to still work, from RESEND_REUSE_MAX_AGE (default 24h).
+func resendReuseMaxAge() time.Duration {
+	return env.Duration("RESEND_REUSE_MAX_AGE", 24*time.Hour)
+}
+
+// reusableLink reports whether the links mailed in row still work. Tokens
+// signed here are verified; tokens from the token service are trusted for
+// resendReuseMaxAge. A local short link must not have expired.
+func reusableLink(ctx context.Context, row *database.MailOutbox) bool {
+	prefix := os.Getenv("URL_LINK_FOLLOW_TOKEN")
+	if prefix == "" || !strings.HasPrefix(row.FullLink, prefix) {
+		return false
+	}
+	token := strings.TrimPrefix(row.FullLink, prefix)
+	if token == "" {
+		return false
+	}
+	if linkIssuer != nil {
+		if _, err := linkIssuer.Verify(token); err != nil {
+			return false
+		}
+	} else if time.Since(row.CreatedAt) > resendReuseMaxAge() {
+		return false
+	}
+
+	if base := os.Getenv("SHORTLINK_BASE_URL"); base != "" {
+		local := strings.TrimRight(base, "/") + "/s/"
+		if code := strings.TrimPrefix(row.ShortLink, local); code != row.ShortLink {
+			if _, err := shortlink.Resolve(ctx, code); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
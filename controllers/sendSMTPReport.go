@@ -1,19 +1,15 @@
 package controllers
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net"
-	"net/http"
-	"net/smtp"
 	"net/url"
 	"os"
 	"strings"
-	"time"
+
+	"pond/format"
+	"pond/repository"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -21,6 +17,8 @@ import (
 type TransferToken struct {
 	TransferID string `json:"transfer_id"`
 	Token      string `json:"token"`
+	StartDate  string `json:"start_date,omitempty"`
+	EndDate    string `json:"end_date,omitempty"`
 }
 
 type TokenResponse struct {
@@ -31,16 +29,6 @@ type ReqToGenTokenPDF struct {
 	TransferId string `json:"transferId"`
 }
 
-type ReqShortLink struct {
-	Link string `json:"link"`
-}
-
-type RespShortLink struct {
-	Data struct {
-		ShortLink string `json:"short-link"`
-	} `json:"data"`
-}
-
 type SendSMTPReportRequest struct {
 	AccountName  string          `json:"accountName"`
 	MinDateTime  string          `json:"minDateTime"`
@@ -76,6 +64,7 @@ func cleanEmails(emails string) []string {
 }
 
 func goToSMTP(
+	transferId string,
 	accountName string,
 	minDateTime string,
 	maxDateTime string,
@@ -84,150 +73,59 @@ func goToSMTP(
 	urlDownload string,
 	mailTo string,
 	randomID int,
+	loc format.Locale,
 ) (ResponseBack, error) {
 
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
 	smtpFrom := os.Getenv("MAIL_FROM")
 	fromName := os.Getenv("MAIL_FROM_NAME")
 
-	reqShortLink := ReqShortLink{Link: urlDownload}
-
-	jsonData, err := json.Marshal(reqShortLink)
-	if err != nil {
-		return ResponseBack{}, err
-	}
-
-	var resp *http.Response
-	maxRetries := 3
-
-	for i := 0; i < maxRetries; i++ {
-		resp, err = http.Post(
-			os.Getenv("URL_ONE_GENERATE_SHOT_LINK"),
-			"application/json",
-			bytes.NewBuffer(jsonData),
-		)
-
-		if err == nil {
-			break
-		}
-
-		if i < maxRetries-1 {
-			time.Sleep(1 * time.Second)
-		}
-	}
-
-	if err != nil {
-		link_r := ResponseBack{
-			Fulllink:  urlDownload,
-			Shortlink: "",
-		}
-		return link_r, err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return ResponseBack{}, err
-	}
-
-	var respShortLink RespShortLink
-	if err := json.Unmarshal(body, &respShortLink); err != nil {
-		return ResponseBack{}, err
-	}
-
-	if respShortLink.Data.ShortLink == "" {
-		return ResponseBack{}, fmt.Errorf("short link empty")
+	short := shortenLink(context.Background(), transferId, urlDownload)
+	link := short
+	if link == "" {
+		link = urlDownload
 	}
 
-	link := respShortLink.Data.ShortLink
-
 	link_r := ResponseBack{
 		Fulllink:  urlDownload,
-		Shortlink: link,
+		Shortlink: short,
 	}
 
 	log.Printf("[%d] Generated Short Link: %s", randomID, link)
 
 	fromHeader := fmt.Sprintf("%s <%s>", fromName, smtpFrom)
-	subject := "รายงานโอนเงินกลับประจำวัน"
-
-	bodyText := fmt.Sprintf(`<p>เรียน %s,</p><br/>
-        <p>ทางบริษัทฯ ส่วนงานการรับชำระเงิน ได้ส่งรายงานการโอนเงิน Online Payment Services (OPS) ประจำวันที่ %s มาให้ท่าน โดยมีรายละเอียดดังนี้</p><br/><br/>
-        <p>จำนวนรายการ : %s รายการ</p>
-        <p>ยอดรับชำระเงิน : %s บาท</p><br/>
-        <p>ทั้งนี้ สามารถดาวน์โหลดรายละเอียดการรับเงินได้ที่ %s</p><br/>
-        <p>หากท่านต้องการข้อมูลเพิ่มเติม โปรดติดต่อ ทางบริษัทฯ ส่วนงานการรับชำระเงิน ผ่านช่องทางต่าง ๆ ดังนี้</p>
-        <p>E-mail : online-support@inet.co.th</p>
-        <p>ขอแสดงความนับถือ</p>
-        <p>บริษัทฯ ส่วนงานการรับชำระเงิน</p>`,
-		accountName, time.Now().Format("02/01/2006"), sumTxnCount, sumTxnAmount, link)
-
-	to := cleanEmails(mailTo)
-	bcc := cleanEmails(os.Getenv("MAIL_BCC"))
-	allRecipients := append(to, bcc...)
-
-	msg := []byte(
-		"Return-Path: " + smtpFrom + "\r\n" +
-			"From: " + fromHeader + "\r\n" +
-			"To: " + strings.Join(to, ",") + "\r\n" +
-			"Subject: " + subject + "\r\n" +
-			"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
-			"Message-ID: <" + fmt.Sprintf("%d", time.Now().UnixNano()) + "@thaidotcompayment.co.th>\r\n" +
-			"MIME-Version: 1.0\r\n" +
-			"Content-Type: text/html; charset=UTF-8\r\n\r\n" +
-			bodyText,
-	)
-
-	conn, err := net.Dial("tcp", smtpHost+":"+smtpPort)
+	data, err := reportData(MailDetail{
+		AccountName:  accountName,
+		MinDateTime:  minDateTime,
+		MaxDateTime:  maxDateTime,
+		SumTxnCount:  sumTxnCount,
+		SumTxnAmount: sumTxnAmount,
+	}, loc)
 	if err != nil {
 		return link_r, err
 	}
-	defer conn.Close()
+	data.Link = link
 
-	client, err := smtp.NewClient(conn, smtpHost)
+	subject, bodyText, text, err := renderMail(templateTransfer, loc.Lang, data)
 	if err != nil {
 		return link_r, err
 	}
-	defer client.Quit()
-
-	if ok, _ := client.Extension("STARTTLS"); ok {
-		tlsConfig := &tls.Config{ServerName: smtpHost}
-		if err := client.StartTLS(tlsConfig); err != nil {
-			return link_r, err
-		}
-	}
-
-	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
-	if err := client.Auth(auth); err != nil {
-		return link_r, err
-	}
 
-	if err := client.Mail(smtpFrom); err != nil {
-		return link_r, err
+	to, rejected := parseRecipients(mailTo)
+	bcc, rejectedBcc := parseRecipients(os.Getenv("MAIL_BCC"))
+	for _, r := range append(rejected, rejectedBcc...) {
+		log.Printf("[%d] invalid recipient %q: %s", randomID, r.Address, r.Reason)
 	}
-
-	for _, addr := range allRecipients {
-		if err := client.Rcpt(addr); err != nil {
-			return link_r, err
-		}
-	}
-
-	w, err := client.Data()
-	if err != nil {
-		return link_r, err
+	if len(to) == 0 {
+		return link_r, fmt.Errorf("no valid recipient email in %q", sanitizeForLog(mailTo))
 	}
+	allRecipients := append(to, bcc...)
 
-	_, err = w.Write(msg)
+	msg, err := buildMessage(smtpFrom, fromHeader, to, subject, bodyText, text)
 	if err != nil {
-		w.Close()
 		return link_r, err
 	}
 
-	err = w.Close()
-	if err != nil {
+	if err := getMailer().Send(smtpFrom, allRecipients, msg); err != nil {
 		return link_r, err
 	}
 
@@ -319,12 +217,6 @@ func SendSMTPReport(c *fiber.Ctx) error {
 	}
 
 	basePDF := os.Getenv("URL_LINKPDF")
-	accountName := "Test Name"
-	minDateTime := "2026-01-01"
-	maxDateTime := "2026-02-01"
-	sumTxnCount := "100"
-	sumTxnAmount := "10000"
-	mailTo := "boxblue779@email.com"
 	randomID := 1000
 
 	for i, t := range tokens {
@@ -334,18 +226,37 @@ func SendSMTPReport(c *fiber.Ctx) error {
 			continue
 		}
 
+		if err := validatePeriod(t.StartDate, t.EndDate); err != nil {
+			log.Printf("[%d] invalid period for transfer_id=%s: %v", i, t.TransferID, err)
+			continue
+		}
+
+		recipient, err := repository.FindRecipient(t.TransferID, "")
+		if err != nil || recipient.Email == "" {
+			log.Printf("[%d] no recipient for transfer_id=%s: %v", i, t.TransferID, err)
+			continue
+		}
+
+		var detail MailDetail
+		if err := loadMailDetail(&detail, t.TransferID, recipient.Name, t.StartDate, t.EndDate); err != nil {
+			log.Printf("[%d] cannot load figures for transfer_id=%s: %v", i, t.TransferID, err)
+			continue
+		}
+
 		longLink := basePDF + t.Token
 		log.Printf("[%d] transfer_id=%s pdf=%s", i, t.TransferID, longLink)
 
 		respBack, err := goToSMTP(
-			accountName,
-			minDateTime,
-			maxDateTime,
-			sumTxnCount,
-			sumTxnAmount,
+			t.TransferID,
+			detail.AccountName,
+			detail.MinDateTime,
+			detail.MaxDateTime,
+			detail.SumTxnCount,
+			detail.SumTxnAmount,
 			longLink,
-			mailTo,
+			recipient.Email,
 			randomID+i,
+			format.NewLocale(recipient.Lang, recipient.BuddhistEra),
 		)
 
 		if err != nil {
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"pond/apikey"
+	"pond/database"
+	"pond/shortlink"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var linkShortener shortlink.Shortener
+
+// InitShortener sets up the short-link chain.
+func InitShortener() error {
+	s, err := shortlink.FromEnv()
+	if err != nil {
+		return err
+	}
+	linkShortener = s
+	return nil
+}
+
+// shortenLink returns "" when every shortener failed; callers then mail the
+// full URL instead.
+func shortenLink(ctx context.Context, transferId, fullUrl string) string {
+	short, err := linkShortener.Shorten(ctx, shortlink.Request{FullURL: fullUrl, TransferId: transferId})
+	if err != nil {
+		log.Printf("%s [SHORTLINK] failed, mailing the full link: %v", transferId, err)
+		return ""
+	}
+	return short
+}
+
+// RedirectShortLink serves GET /s/:code for links made by the local
+// shortener and records the click.
+func RedirectShortLink(c *fiber.Ctx) error {
+	link, err := shortlink.Resolve(c.UserContext(), c.Params("code"))
+	if errors.Is(err, shortlink.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).SendString("link not found")
+	}
+	if errors.Is(err, shortlink.ErrExpired) {
+		return c.Status(fiber.StatusGone).SendString("link expired")
+	}
+	if err != nil {
+		log.Printf("[SHORTLINK] resolve %q error: %v", c.Params("code"), err)
+		return c.Status(fiber.StatusInternalServerError).SendString("cannot resolve link")
+	}
+
+	if err := shortlink.RecordClick(c.UserContext(), link, c.Get(fiber.HeaderUserAgent), c.IP()); err != nil {
+		log.Printf("%s [SHORTLINK] record click %q error: %v", link.TransferId, link.Code, err)
+	}
+	return c.Redirect(link.FullURL, fiber.StatusFound)
+}
+
+// GetLinkClicks lists the local short links of a transfer with their
+// clicks, newest first. Only links mailed in reports of a type the client
+// is allowed are shown.
+func GetLinkClicks(c *fiber.Ctx) error {
+	db := database.DBConn
+	client := c.Locals(apiClientLocal).(*apikey.Client)
+	transferId := c.Params("transferId")
+
+	mailed := db.Model(&database.MailOutbox{}).
+		Select("full_link").
+		Where("transfer_id = ? AND type IN ?", transferId, client.AllowedTypes)
+
+	var links []database.ShortLink
+	err := db.Where("transfer_id = ? AND full_url IN (?)", transferId, mailed).
+		Order("created_at DESC").
+		Find(&links).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":  "Failed to load links",
+			"detail": err.Error(),
+		})
+	}
+
+	var clicks []database.ShortLinkClick
+	if len(links) > 0 {
+		codes := make([]string, len(links))
+		for i, l := range links {
+			codes[i] = l.Code
+		}
+		if err := db.Where("code IN ?", codes).Order("clicked_at DESC").Find(&clicks).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error":  "Failed to load clicks",
+				"detail": err.Error(),
+			})
+		}
+	}
+
+	byCode := map[string][]fiber.Map{}
+	for _, k := range clicks {
+		byCode[k.Code] = append(byCode[k.Code], fiber.Map{
+			"clicked_at": k.ClickedAt,
+			"user_agent": k.UserAgent,
+			"ip":         k.IP,
+		})
+	}
+
+	out := make([]fiber.Map, 0, len(links))
+	for _, l := range links {
+		out = append(out, fiber.Map{
+			"code":       l.Code,
+			"full_url":   l.FullUrl,
+			"created_at": l.CreatedAt,
+			"expires_at": l.ExpiresAt,
+			"clicks":     len(byCode[l.Code]),
+			"history":    byCode[l.Code],
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"responseCode": "00",
+		"transfer_id":  transferId,
+		"opened":       len(clicks) > 0,
+		"links":        out,
+	})
+}
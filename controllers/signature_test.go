@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"pond/database"
+	"pond/signing"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+const (
+	testMaster = "master-key-for-tests"
+	testAPIKey = "pk_test_0001"
+)
+
+// appTransport serves http.Client requests from app without a listener.
+type appTransport struct{ app *fiber.App }
+
+func (t appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.app.Test(req, -1)
+}
+
+// signatureApp puts VerifySignature in front of a handler that answers 200.
+// The nonce store runs against a dry-run connection, so no MySQL is needed.
+func signatureApp(t *testing.T) *fiber.App {
+	t.Helper()
+	t.Setenv("REQUEST_SIGNING_MASTER_KEY", testMaster)
+	t.Setenv("REQUIRE_REQUEST_SIGNATURE", "")
+
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "test:test@tcp(127.0.0.1:3306)/test",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := database.DBConn
+	database.DBConn = db
+	t.Cleanup(func() { database.DBConn = prev })
+
+	app := fiber.New()
+	app.Post("/SMTP", VerifySignature, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	return app
+}
+
+func TestSignedClientPasses(t *testing.T) {
+	app := signatureApp(t)
+	client := &signing.Client{
+		BaseURL: "http://mail.test",
+		APIKey:  testAPIKey,
+		Secret:  signing.SecretFor([]byte(testMaster), testAPIKey),
+		HTTP:    &http.Client{Transport: appTransport{app}},
+	}
+
+	resp, err := client.Do(context.Background(), http.MethodPost, "/SMTP?async=1", []byte(`{"type":"Transfer"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestTamperedRequestFails(t *testing.T) {
+	app := signatureApp(t)
+	secret := signing.SecretFor([]byte(testMaster), testAPIKey)
+	body := []byte(`{"type":"Transfer"}`)
+
+	cases := []struct {
+		name   string
+		uri    string
+		body   []byte
+		header func(h http.Header)
+	}{
+		{name: "body", uri: "/SMTP?async=1", body: []byte(`{"type":"Income"}`)},
+		{name: "path", uri: "/SMTP?async=0", body: body},
+		{name: "timestamp", uri: "/SMTP?async=1", body: body, header: func(h http.Header) {
+			ts, _ := strconv.ParseInt(h.Get(signing.HeaderTimestamp), 10, 64)
+			h.Set(signing.HeaderTimestamp, strconv.FormatInt(ts-1, 10))
+		}},
+		{name: "unsigned", uri: "/SMTP?async=1", body: body, header: func(h http.Header) {
+			h.Del(signing.HeaderSignature)
+		}},
+	}
+	for _, c := range cases {
+		signed := httptest.NewRequest(http.MethodPost, "/SMTP?async=1", bytes.NewReader(body))
+		if err := signing.SignRequest(signed, testAPIKey, secret, body); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, c.uri, bytes.NewReader(c.body))
+		req.Header = signed.Header.Clone()
+		if c.header != nil {
+			c.header(req.Header)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", c.name, resp.StatusCode)
+		}
+	}
+}
+
+func TestStaleTimestampFails(t *testing.T) {
+	app := signatureApp(t)
+	secret := signing.SecretFor([]byte(testMaster), testAPIKey)
+	body := []byte(`{}`)
+
+	ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/SMTP", bytes.NewReader(body))
+	req.Header.Set(signing.HeaderAPIKey, testAPIKey)
+	req.Header.Set(signing.HeaderTimestamp, ts)
+	req.Header.Set(signing.HeaderNonce, "n1")
+	req.Header.Set(signing.HeaderSignature, signing.Sign(secret, http.MethodPost, "/SMTP", ts, "n1", body))
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", resp.StatusCode)
+	}
+}
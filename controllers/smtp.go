@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"net/mail"
+	"os"
+
+	"pond/mailer"
+)
+
+var smtpPool *mailer.Pool
+
+// InitMailer builds the SMTP session pool shared by every sender in this
+// package.
+func InitMailer() error {
+	cfg, err := mailer.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	smtpPool, err = mailer.NewPool(cfg)
+	return err
+}
+
+func getMailer() *mailer.Pool {
+	return smtpPool
+}
+
+// buildMessage renders an HTML email through mailer.Message. fromHeader is
+// "Name <addr>"; when it does not parse, the bare envelope sender is used.
+func buildMessage(returnPath, fromHeader string, to []string, subject, htmlBody, textBody string, attachments ...mailer.Attachment) ([]byte, error) {
+	from, err := mail.ParseAddress(fromHeader)
+	if err != nil {
+		from = &mail.Address{Address: returnPath}
+	}
+
+	rcpts := make([]mail.Address, len(to))
+	for i, addr := range to {
+		rcpts[i] = mail.Address{Address: addr}
+	}
+
+	m := mailer.Message{
+		ReturnPath:  returnPath,
+		From:        *from,
+		To:          rcpts,
+		Subject:     subject,
+		HTML:        htmlBody,
+		Text:        textBody,
+		Encoding:    os.Getenv("MAIL_BODY_ENCODING"),
+		Attachments: attachments,
+	}
+	return m.Bytes()
+}
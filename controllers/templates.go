@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"pond/format"
+)
+
+const (
+	templateTransfer  = "transfer"
+	templateIncome    = "income"
+	templateException = "exception"
+)
+
+// ReportData is what the transfer and income templates see.
+type ReportData struct {
+	TransferId  string
+	Corporation string
+	PeriodStart string
+	PeriodEnd   string
+	TxnCount    string
+	TxnAmount   string
+	Link        string
+}
+
+// ExceptionData is what the exception template sees.
+type ExceptionData struct {
+	CaseNumber string
+	Accounts   []string
+}
+
+type templateSet struct {
+	subject *texttemplate.Template
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+}
+
+// แต่ละชุดต้องมี subject.tmpl, body.html.tmpl และ body.txt.tmpl
+var requiredTemplates = map[string]interface{}{
+	templateTransfer:  ReportData{},
+	templateIncome:    ReportData{},
+	templateException: ExceptionData{},
+}
+
+var templateFuncs = map[string]interface{}{
+	"inc": func(i int) int { return i + 1 },
+}
+
+// mailTemplates is keyed by language, then template name.
+var mailTemplates map[string]map[string]*templateSet
+
+// LoadTemplates parses the template sets under TEMPLATE_DIR/<lang>/<name>
+// (default "templates") and dry-runs each one so a broken file stops the
+// service at startup instead of failing a batch. Every set must exist for
+// the default language (th); other languages may leave sets out and fall
+// back to it.
+func LoadTemplates() error {
+	dir := os.Getenv("TEMPLATE_DIR")
+	if dir == "" {
+		dir = "templates"
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	langs := map[string]map[string]*templateSet{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		lang := e.Name()
+		sets := map[string]*templateSet{}
+		for name, sample := range requiredTemplates {
+			setDir := filepath.Join(dir, lang, name)
+			if _, err := os.Stat(setDir); err != nil {
+				if lang == format.LangThai {
+					return fmt.Errorf("template %s/%s: %w", lang, name, err)
+				}
+				continue
+			}
+			set, err := loadTemplateSet(setDir)
+			if err != nil {
+				return err
+			}
+			if err := set.execute(io.Discard, io.Discard, io.Discard, sample); err != nil {
+				return fmt.Errorf("template %s/%s: %w", lang, name, err)
+			}
+			sets[name] = set
+		}
+		langs[lang] = sets
+	}
+	if _, ok := langs[format.LangThai]; !ok {
+		return fmt.Errorf("no %s templates in %s", format.LangThai, dir)
+	}
+
+	mailTemplates = langs
+	return nil
+}
+
+func loadTemplateSet(dir string) (*templateSet, error) {
+	subject, err := texttemplate.New("subject.tmpl").Funcs(templateFuncs).ParseFiles(filepath.Join(dir, "subject.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	html, err := htmltemplate.New("body.html.tmpl").Funcs(templateFuncs).ParseFiles(filepath.Join(dir, "body.html.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	text, err := texttemplate.New("body.txt.tmpl").Funcs(templateFuncs).ParseFiles(filepath.Join(dir, "body.txt.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	return &templateSet{subject: subject, html: html, text: text}, nil
+}
+
+func (s *templateSet) execute(subject, html, text io.Writer, data interface{}) error {
+	if err := s.subject.Execute(subject, data); err != nil {
+		return err
+	}
+	if err := s.html.Execute(html, data); err != nil {
+		return err
+	}
+	return s.text.Execute(text, data)
+}
+
+// renderMail returns the subject, HTML and plain-text bodies for a template
+// set in lang, falling back to Thai. The subject is trimmed to a single line.
+func renderMail(name, lang string, data interface{}) (string, string, string, error) {
+	set, ok := mailTemplates[lang][name]
+	if !ok {
+		set, ok = mailTemplates[format.LangThai][name]
+	}
+	if !ok {
+		return "", "", "", fmt.Errorf("no mail template %q loaded", name)
+	}
+
+	var subject, html, text bytes.Buffer
+	if err := set.execute(&subject, &html, &text, data); err != nil {
+		return "", "", "", err
+	}
+	return strings.TrimSpace(subject.String()), html.String(), text.String(), nil
+}
+
+// reportTemplate maps ReceiveResFormat.Type to its template set.
+func reportTemplate(reportType string) string {
+	if strings.EqualFold(reportType, "Income") {
+		return templateIncome
+	}
+	return templateTransfer
+}
+
+// exceptionLang is the language of the exception email sent to
+// SMTP_SUPPORT, from EXCEPTION_MAIL_LANG (default th).
+func exceptionLang() string {
+	return format.NewLocale(os.Getenv("EXCEPTION_MAIL_LANG"), false).Lang
+}
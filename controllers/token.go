@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"pond/env"
+	"pond/linktoken"
+	"pond/tokensvc"
+)
+
+// linkIssuer is set when TOKEN_PROVIDER=local; tokens are then signed here
+// instead of being requested from URL_ONE_GENERATE_TOKEN.
+var linkIssuer *linktoken.Issuer
+
+// InitTokenProvider reads TOKEN_PROVIDER (remote, the default, or local).
+func InitTokenProvider() error {
+	switch os.Getenv("TOKEN_PROVIDER") {
+	case "", "remote":
+		linkIssuer = nil
+		return nil
+	case "local":
+		issuer, err := linktoken.IssuerFromEnv()
+		if err != nil {
+			return err
+		}
+		linkIssuer = issuer
+		return nil
+	}
+	return fmt.Errorf("unknown TOKEN_PROVIDER %q", os.Getenv("TOKEN_PROVIDER"))
+}
+
+// issueTokens returns a token or an error for every detail, in order, from
+// the configured provider.
+func issueTokens(ctx context.Context, reportType string, details []DetailRes) ([]string, []error) {
+	if linkIssuer == nil {
+		return fetchTokens(ctx, tokensvc.NewFromEnv(), reportType, details)
+	}
+
+	tokens := make([]string, len(details))
+	errs := make([]error, len(details))
+	for i, d := range details {
+		tokens[i], errs[i] = linkIssuer.Issue(d.TransferId, d.RecipientId)
+	}
+	return tokens, errs
+}
+
+// fetchTokens returns a token or an error for every detail, in order. In
+// batch mode the transfers go out in chunks of client.BatchSize; a chunk
+// that fails, or a transfer the batch answer leaves out, is retried with
+// one call per transfer.
+func fetchTokens(ctx context.Context, client *tokensvc.Client, reportType string, details []DetailRes) ([]string, []error) {
+	tokens := make([]string, len(details))
+	errs := make([]error, len(details))
+
+	single := func(i int) {
+		tokens[i], errs[i] = client.Token(ctx, details[i].TransferId)
+	}
+
+	if client.BatchSize <= 0 {
+		runPool(env.Int("TOKEN_WORKERS", 4), len(details), single)
+		return tokens, errs
+	}
+
+	size := client.BatchSize
+	chunks := (len(details) + size - 1) / size
+	missing := make([][]int, chunks)
+	runPool(env.Int("TOKEN_WORKERS", 4), chunks, func(c int) {
+		start, end := c*size, (c+1)*size
+		if end > len(details) {
+			end = len(details)
+		}
+		ids := make([]string, 0, end-start)
+		for i := start; i < end; i++ {
+			ids = append(ids, details[i].TransferId)
+		}
+
+		got, err := client.Batch(ctx, reportType, ids)
+		for i := start; i < end; i++ {
+			if t, ok := got[details[i].TransferId]; ok && err == nil {
+				tokens[i] = t
+			} else {
+				missing[c] = append(missing[c], i)
+			}
+		}
+		if err != nil {
+			log.Printf("[TOKEN] batch of %d failed, falling back to single calls: %v", len(ids), err)
+		}
+	})
+
+	var retry []int
+	for _, m := range missing {
+		retry = append(retry, m...)
+	}
+	runPool(env.Int("TOKEN_WORKERS", 4), len(retry), func(j int) {
+		single(retry[j])
+	})
+
+	return tokens, errs
+}
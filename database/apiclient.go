@@ -0,0 +1,34 @@
+package database
+
+import "time"
+
+// ApiClient is one upstream system allowed to call the API.
+type ApiClient struct {
+	ID           uint   `gorm:"primaryKey"`
+	Name         string `gorm:"size:64;uniqueIndex"`
+	AllowedTypes string `gorm:"size:64"` // comma separated, e.g. "Transfer,Income"
+	Enabled      bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (ApiClient) TableName() string {
+	return "api_client"
+}
+
+// ApiClientKey is a key of an ApiClient. Only the SHA-256 of the key is
+// stored. A client can have several keys valid at once during rotation.
+type ApiClientKey struct {
+	ID        uint   `gorm:"primaryKey"`
+	ClientId  uint   `gorm:"index"`
+	KeyHash   string `gorm:"size:64;uniqueIndex"`
+	Prefix    string `gorm:"size:16;index"`
+	Enabled   bool
+	NotBefore time.Time
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+}
+
+func (ApiClientKey) TableName() string {
+	return "api_client_key"
+}
@@ -1,9 +1,43 @@
 package database
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
 var (
 	DBConn *gorm.DB
 )
+
+// Open connects DBConn using the DATABASE_* settings.
+func Open() error {
+	dsn := fmt.Sprintf(
+		"%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&loc=Local",
+		os.Getenv("DATABASE_USER"),
+		"",
+		os.Getenv("DATABASE_HOST"),
+		os.Getenv("DATABASE_PORT"),
+		os.Getenv("DATABASE_NAME"),
+	)
+	var err error
+	DBConn, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	return err
+}
+
+// IsDuplicateKey reports a unique-key violation, whether or not gorm was
+// set up to translate MySQL error 1062.
+func IsDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "Duplicate entry")
+}
+
+func Migrate() error {
+	return DBConn.AutoMigrate(&MailOutbox{}, &MailJob{}, &ShortLink{}, &ShortLinkClick{}, &ApiClient{}, &ApiClientKey{}, &RequestNonce{}, &IdempotencyKey{}, &ReportDelivery{})
+}
@@ -0,0 +1,26 @@
+package database
+
+import "time"
+
+const (
+	IdempotencyRunning = "RUNNING"
+	IdempotencyDone    = "DONE"
+)
+
+// IdempotencyKey keeps the response of a POST /SMTP sent with an
+// Idempotency-Key header so a retry gets the same answer instead of a
+// second round of emails.
+type IdempotencyKey struct {
+	ClientName  string `gorm:"primaryKey;size:64"`
+	Key         string `gorm:"primaryKey;size:128"`
+	RequestHash string `gorm:"size:64"`
+	Status      string `gorm:"size:16"`
+	StatusCode  int
+	Response    string    `gorm:"type:longtext"`
+	CreatedAt   time.Time `gorm:"index"`
+	UpdatedAt   time.Time
+}
+
+func (IdempotencyKey) TableName() string {
+	return "idempotency_key"
+}
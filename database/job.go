@@ -0,0 +1,29 @@
+package database
+
+import "time"
+
+const (
+	JobQueued  = "QUEUED"
+	JobRunning = "RUNNING"
+	JobDone    = "DONE"
+	JobFailed  = "FAILED"
+)
+
+// MailJob is one asynchronous POST /SMTP submission. Its results are the
+// mail_outbox rows carrying the same JobId.
+type MailJob struct {
+	ID         string `gorm:"primaryKey;size:36"`
+	Type       string `gorm:"size:16"`
+	ClientName string `gorm:"size:64;index"` // API client that submitted it
+	Status     string `gorm:"size:16;index"`
+	Total      int
+	Request    string `gorm:"type:longtext"`
+	Error      string `gorm:"type:text"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+func (MailJob) TableName() string {
+	return "mail_job"
+}
@@ -0,0 +1,15 @@
+package database
+
+import "time"
+
+// RequestNonce remembers the nonce of a signed request for as long as its
+// timestamp would be accepted, so a replay is rejected.
+type RequestNonce struct {
+	KeyHash   string    `gorm:"primaryKey;size:64"`
+	Nonce     string    `gorm:"primaryKey;size:64"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (RequestNonce) TableName() string {
+	return "request_nonce"
+}
@@ -0,0 +1,45 @@
+package database
+
+import "time"
+
+const (
+	OutboxPending = "PENDING"
+	OutboxSending = "SENDING"
+	OutboxSuccess = "SUCCESS"
+	OutboxFail    = "FAIL"
+)
+
+// MailOutbox is one rendered report email. Rows are written before any SMTP
+// attempt so a crash mid-batch can be resumed from the table.
+type MailOutbox struct {
+	ID          uint   `gorm:"primaryKey"`
+	JobId       string `gorm:"size:36;index"`
+	TransferId  string `gorm:"size:64;index;index:idx_outbox_report,priority:1"`
+	FromHeader  string `gorm:"size:255"`
+	Recipients  string `gorm:"type:text"`
+	Bcc         string `gorm:"type:text"`
+	Subject     string `gorm:"size:255"`
+	Body        string `gorm:"type:longtext"`
+	TextBody    string `gorm:"type:longtext"`
+	Type        string `gorm:"size:16;index:idx_outbox_report,priority:2"`
+	PeriodStart string `gorm:"size:10;index:idx_outbox_report,priority:3"`
+	PeriodEnd   string `gorm:"size:10;index:idx_outbox_report,priority:4"`
+	RecipientId string `gorm:"size:64"`
+	Stage       string `gorm:"size:16"`
+	ShortLink   string `gorm:"size:512"`
+	FullLink    string `gorm:"size:1024"`
+	Corporation string `gorm:"size:255"`
+	Corpemail   string `gorm:"type:text"`
+	AttachPdf   bool
+	Reserved    bool   // holds the report_delivery reservation of its report
+	Status      string `gorm:"size:16;index"`
+	Attempts    int
+	LastError   string `gorm:"type:text"`
+	SmtpReply   string `gorm:"size:512"` // server reply to the last DATA, e.g. its queue id
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (MailOutbox) TableName() string {
+	return "mail_outbox"
+}
@@ -0,0 +1,27 @@
+package database
+
+import "time"
+
+const (
+	ReportReserved = "RESERVED"
+	ReportSent     = "SENT"
+)
+
+// ReportDelivery holds one report (transfer, type, period and recipient)
+// while a request is sending it and remembers it once sent. The unique key
+// is what stops two concurrent or retried requests from both mailing it.
+type ReportDelivery struct {
+	ID          uint   `gorm:"primaryKey"`
+	TransferId  string `gorm:"size:64;uniqueIndex:idx_report_delivery,priority:1"`
+	Type        string `gorm:"size:16;uniqueIndex:idx_report_delivery,priority:2"`
+	PeriodStart string `gorm:"size:10;uniqueIndex:idx_report_delivery,priority:3"`
+	PeriodEnd   string `gorm:"size:10;uniqueIndex:idx_report_delivery,priority:4"`
+	RecipientId string `gorm:"size:64;uniqueIndex:idx_report_delivery,priority:5"`
+	Status      string `gorm:"size:16"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (ReportDelivery) TableName() string {
+	return "report_delivery"
+}
@@ -0,0 +1,30 @@
+package database
+
+import "time"
+
+// ShortLink is a code served by the app's own /s/:code redirect.
+type ShortLink struct {
+	Code       string `gorm:"primaryKey;size:16"`
+	FullUrl    string `gorm:"type:text"`
+	TransferId string `gorm:"size:64;index"`
+	ExpiresAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (ShortLink) TableName() string {
+	return "short_link"
+}
+
+// ShortLinkClick is one redirect served for a ShortLink.
+type ShortLinkClick struct {
+	ID         uint   `gorm:"primaryKey"`
+	Code       string `gorm:"size:16;index"`
+	TransferId string `gorm:"size:64;index"`
+	UserAgent  string `gorm:"size:512"`
+	IP         string `gorm:"size:64"`
+	ClickedAt  time.Time
+}
+
+func (ShortLinkClick) TableName() string {
+	return "short_link_click"
+}
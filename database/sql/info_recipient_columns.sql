@@ -0,0 +1,10 @@
+-- Columns on the external `info` table read by repository.FindRecipient.
+-- Migrate() does not touch `info`; run this once where the table lives.
+-- The service also works without them: no PDF attachment, Thai language,
+-- Gregorian years and one recipient per transfer.
+ALTER TABLE info
+  ADD COLUMN ATTACH_PDF   TINYINT(1)  NOT NULL DEFAULT 0,
+  ADD COLUMN LANG         VARCHAR(8)  NOT NULL DEFAULT 'th',
+  ADD COLUMN BUDDHIST_ERA TINYINT(1)  NOT NULL DEFAULT 0,
+  ADD COLUMN RECIPIENT_ID VARCHAR(64) NULL,
+  ADD INDEX idx_info_transfer_recipient (TRANSFER_ID, RECIPIENT_ID);
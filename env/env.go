@@ -0,0 +1,54 @@
+package env
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Int returns the positive integer in key, or def when it is unset or not
+// a positive integer.
+func Int(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n < 1 {
+		return def
+	}
+	return n
+}
+
+// NonNegInt is Int that also accepts 0, e.g. for retry counts.
+func NonNegInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// Int64 is Int for values that may not fit an int, e.g. byte sizes.
+func Int64(key string, def int64) int64 {
+	n, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || n < 1 {
+		return def
+	}
+	return n
+}
+
+// Duration returns the positive time.ParseDuration value in key, or def.
+func Duration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// Bool returns the strconv.ParseBool value in key, or def when it is unset
+// or not a boolean.
+func Bool(key string, def bool) bool {
+	b, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return b
+}
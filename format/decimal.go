@@ -0,0 +1,82 @@
+package format
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Decimal is an exact base-10 number, value / 10^scale. Amounts come from
+// MySQL DECIMAL columns as strings and never go through float64.
+type Decimal struct {
+	value *big.Int
+	scale int
+}
+
+// ParseDecimal accepts an optional sign, digits and an optional fraction,
+// e.g. "1234567.5" or "-0.25".
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	digits := strings.TrimLeft(s, "+-")
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole == "" && frac == "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	if len(s)-len(digits) > 1 {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+	}
+
+	v, _ := new(big.Int).SetString("0"+whole+frac, 10)
+	if strings.HasPrefix(s, "-") {
+		v.Neg(v)
+	}
+	return Decimal{value: v, scale: len(frac)}, nil
+}
+
+// Round returns d rounded half away from zero to places decimals.
+func (d Decimal) Round(places int) Decimal {
+	v := d.value
+	if v == nil {
+		v = new(big.Int)
+	}
+	if d.scale <= places {
+		shift := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places-d.scale)), nil)
+		return Decimal{value: new(big.Int).Mul(v, shift), scale: places}
+	}
+
+	div := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.scale-places)), nil)
+	q, r := new(big.Int).QuoRem(new(big.Int).Abs(v), div, new(big.Int))
+	if r.Lsh(r, 1).Cmp(div) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if v.Sign() < 0 {
+		q.Neg(q)
+	}
+	return Decimal{value: q, scale: places}
+}
+
+// String prints d with exactly its scale in decimals.
+func (d Decimal) String() string {
+	v := d.value
+	if v == nil {
+		v = new(big.Int)
+	}
+	digits := new(big.Int).Abs(v).String()
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+	}
+
+	sign := ""
+	if v.Sign() < 0 {
+		sign = "-"
+	}
+	if d.scale == 0 {
+		return sign + digits
+	}
+	return sign + digits[:len(digits)-d.scale] + "." + digits[len(digits)-d.scale:]
+}
@@ -0,0 +1,75 @@
+package format
+
+import "testing"
+
+func TestParseDecimal(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"1234567.5", "1234567.5"},
+		{"-0.25", "-0.25"},
+		{"+42", "42"},
+		{" 7.000 ", "7.000"},
+		{".5", "0.5"},
+		{"12.", "12"},
+		{"0.123456789012345678901234567890", "0.123456789012345678901234567890"},
+	}
+	for _, c := range cases {
+		d, err := ParseDecimal(c.in)
+		if err != nil {
+			t.Errorf("ParseDecimal(%q): %v", c.in, err)
+			continue
+		}
+		if got := d.String(); got != c.want {
+			t.Errorf("ParseDecimal(%q) = %s, want %s", c.in, got, c.want)
+		}
+	}
+
+	for _, in := range []string{"", ".", "-", "--1", "1.2.3", "1,000", "abc", "1e3"} {
+		if _, err := ParseDecimal(in); err == nil {
+			t.Errorf("ParseDecimal(%q) accepted", in)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	cases := []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"1.005", 2, "1.01"},
+		{"1.004", 2, "1.00"},
+		{"-1.005", 2, "-1.01"},
+		{"-1.004", 2, "-1.00"},
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"0.125", 2, "0.13"},
+		{"1234567.49999", 2, "1234567.50"},
+		{"0.0049", 2, "0.00"},
+		{"-0.004", 2, "0.00"},
+		{"9.995", 2, "10.00"},
+		{"1.5", 2, "1.50"},
+		{"42", 2, "42.00"},
+		// ทศนิยมเกิน 2 ตำแหน่งจาก DECIMAL(20,6)
+		{"1234567.505000", 2, "1234567.51"},
+		{"0.1", 4, "0.1000"},
+	}
+	for _, c := range cases {
+		d, err := ParseDecimal(c.in)
+		if err != nil {
+			t.Fatalf("ParseDecimal(%q): %v", c.in, err)
+		}
+		if got := d.Round(c.places).String(); got != c.want {
+			t.Errorf("Round(%s, %d) = %s, want %s", c.in, c.places, got, c.want)
+		}
+	}
+}
+
+func TestZeroDecimal(t *testing.T) {
+	var d Decimal
+	if got := d.String(); got != "0" {
+		t.Errorf("zero Decimal = %q, want 0", got)
+	}
+	if got := d.Round(2).String(); got != "0.00" {
+		t.Errorf("zero Decimal rounded = %q, want 0.00", got)
+	}
+}
@@ -0,0 +1,89 @@
+package format
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LangThai    = "th"
+	LangEnglish = "en"
+)
+
+// Locale is how one corporation wants numbers and dates in its report.
+type Locale struct {
+	Lang        string
+	BuddhistEra bool
+}
+
+var thaiMonths = [...]string{
+	"มกราคม", "กุมภาพันธ์", "มีนาคม", "เมษายน", "พฤษภาคม", "มิถุนายน",
+	"กรกฎาคม", "สิงหาคม", "กันยายน", "ตุลาคม", "พฤศจิกายน", "ธันวาคม",
+}
+
+// NewLocale normalizes the LANG value from the info table; anything other
+// than "en" is Thai.
+func NewLocale(lang string, buddhistEra bool) Locale {
+	if strings.EqualFold(strings.TrimSpace(lang), LangEnglish) {
+		return Locale{Lang: LangEnglish, BuddhistEra: buddhistEra}
+	}
+	return Locale{Lang: LangThai, BuddhistEra: buddhistEra}
+}
+
+// Amount renders an amount as "1,234,567.50 บาท" (or "THB" in English).
+func (l Locale) Amount(d Decimal) string {
+	s := d.Round(2).String()
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, _ := strings.Cut(s, ".")
+
+	out := groupThousands(whole) + "." + frac
+	if neg {
+		out = "-" + out
+	}
+	if l.Lang == LangEnglish {
+		return out + " THB"
+	}
+	return out + " บาท"
+}
+
+// Count renders an integer count with thousands separators.
+func (l Locale) Count(n int64) string {
+	if n < 0 {
+		return "-" + groupThousands(strconv.FormatInt(-n, 10))
+	}
+	return groupThousands(strconv.FormatInt(n, 10))
+}
+
+// Date renders t as "18 ตุลาคม 2569" (Buddhist Era) or "18 ตุลาคม 2026";
+// English uses "18 October 2026".
+func (l Locale) Date(t time.Time) string {
+	year := t.Year()
+	if l.BuddhistEra {
+		year += 543
+	}
+	month := t.Month().String()
+	if l.Lang != LangEnglish {
+		month = thaiMonths[t.Month()-1]
+	}
+	return strconv.Itoa(t.Day()) + " " + month + " " + strconv.Itoa(year)
+}
+
+func groupThousands(digits string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
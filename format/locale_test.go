@@ -0,0 +1,78 @@
+package format
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAmount(t *testing.T) {
+	th := NewLocale("th", false)
+	en := NewLocale("EN", false)
+	cases := []struct {
+		loc  Locale
+		in   string
+		want string
+	}{
+		{th, "1234567.5", "1,234,567.50 บาท"},
+		{en, "1234567.5", "1,234,567.50 THB"},
+		{th, "0", "0.00 บาท"},
+		{th, "999.999", "1,000.00 บาท"},
+		{th, "100", "100.00 บาท"},
+		{th, "1000", "1,000.00 บาท"},
+		{th, "-1234.565", "-1,234.57 บาท"},
+		{th, "-0.001", "0.00 บาท"},
+		{th, "12345678901234567890.125", "12,345,678,901,234,567,890.13 บาท"},
+	}
+	for _, c := range cases {
+		d, err := ParseDecimal(c.in)
+		if err != nil {
+			t.Fatalf("ParseDecimal(%q): %v", c.in, err)
+		}
+		if got := c.loc.Amount(d); got != c.want {
+			t.Errorf("%s Amount(%s) = %q, want %q", c.loc.Lang, c.in, got, c.want)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	loc := NewLocale("th", false)
+	cases := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{123456, "123,456"},
+		{1234567, "1,234,567"},
+		{-1234567, "-1,234,567"},
+	}
+	for _, c := range cases {
+		if got := loc.Count(c.in); got != c.want {
+			t.Errorf("Count(%d) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestDate(t *testing.T) {
+	day := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.Local)
+	cases := []struct {
+		loc  Locale
+		want string
+	}{
+		{NewLocale("th", true), "18 ตุลาคม 2569"},
+		{NewLocale("th", false), "18 ตุลาคม 2026"},
+		{NewLocale("", true), "18 ตุลาคม 2569"},
+		{NewLocale("en", false), "18 October 2026"},
+		{NewLocale("en", true), "18 October 2569"},
+	}
+	for _, c := range cases {
+		if got := c.loc.Date(day); got != c.want {
+			t.Errorf("%+v Date = %q, want %q", c.loc, got, c.want)
+		}
+	}
+
+	if got := NewLocale("th", true).Date(time.Date(2027, time.January, 1, 0, 0, 0, 0, time.Local)); got != "1 มกราคม 2570" {
+		t.Errorf("Date(2027-01-01) = %q", got)
+	}
+}
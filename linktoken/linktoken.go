@@ -0,0 +1,237 @@
+package linktoken
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrMalformed = errors.New("linktoken: malformed token")
+	ErrSignature = errors.New("linktoken: bad signature")
+	ErrExpired   = errors.New("linktoken: token expired")
+)
+
+// Claims is what a token carries.
+type Claims struct {
+	TransferId string `json:"sub"`
+	Recipient  string `json:"rcp,omitempty"`
+	IssuedAt   int64  `json:"iat"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// Issuer signs download tokens with either an HMAC secret or an Ed25519
+// key. Tokens are compact JWTs (HS256 or EdDSA), so the download service can
+// check them with VerifyHMAC/VerifyEd25519 or any JWT library.
+type Issuer struct {
+	alg     string
+	secret  []byte
+	edKey   ed25519.PrivateKey
+	ttl     time.Duration
+	nowFunc func() time.Time
+}
+
+func NewHMACIssuer(secret []byte, ttl time.Duration) *Issuer {
+	return &Issuer{alg: AlgHS256, secret: secret, ttl: ttl, nowFunc: time.Now}
+}
+
+func NewEd25519Issuer(key ed25519.PrivateKey, ttl time.Duration) *Issuer {
+	return &Issuer{alg: AlgEdDSA, edKey: key, ttl: ttl, nowFunc: time.Now}
+}
+
+// IssuerFromEnv reads LINK_TOKEN_ALG (hmac, the default, or ed25519),
+// LINK_TOKEN_SECRET or LINK_TOKEN_PRIVATE_KEY_PATH (PKCS#8 PEM), and
+// LINK_TOKEN_TTL (default 720h).
+func IssuerFromEnv() (*Issuer, error) {
+	ttl := 720 * time.Hour
+	if s := os.Getenv("LINK_TOKEN_TTL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid LINK_TOKEN_TTL %q", s)
+		}
+		ttl = d
+	}
+
+	switch strings.ToLower(os.Getenv("LINK_TOKEN_ALG")) {
+	case "", "hmac", "hs256":
+		secret := os.Getenv("LINK_TOKEN_SECRET")
+		if len(secret) < 32 {
+			return nil, errors.New("LINK_TOKEN_SECRET must be at least 32 bytes")
+		}
+		return NewHMACIssuer([]byte(secret), ttl), nil
+	case "ed25519", "eddsa":
+		raw, err := os.ReadFile(os.Getenv("LINK_TOKEN_PRIVATE_KEY_PATH"))
+		if err != nil {
+			return nil, fmt.Errorf("read LINK_TOKEN_PRIVATE_KEY_PATH: %w", err)
+		}
+		key, err := ParseEd25519PrivateKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		return NewEd25519Issuer(key, ttl), nil
+	}
+	return nil, fmt.Errorf("unknown LINK_TOKEN_ALG %q", os.Getenv("LINK_TOKEN_ALG"))
+}
+
+// Issue returns a token for transferId and recipient that expires after the
+// issuer's TTL.
+func (i *Issuer) Issue(transferId, recipient string) (string, error) {
+	now := i.nowFunc()
+	return i.sign(Claims{
+		TransferId: transferId,
+		Recipient:  recipient,
+		IssuedAt:   now.Unix(),
+		ExpiresAt:  now.Add(i.ttl).Unix(),
+	})
+}
+
+func (i *Issuer) sign(c Claims) (string, error) {
+	h, err := json.Marshal(header{Alg: i.alg, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signing := enc(h) + "." + enc(p)
+
+	var sig []byte
+	switch i.alg {
+	case AlgHS256:
+		sig = hmacSHA256(i.secret, signing)
+	case AlgEdDSA:
+		sig = ed25519.Sign(i.edKey, []byte(signing))
+	}
+	return signing + "." + enc(sig), nil
+}
+
+// Verify checks a token made by this issuer, including its expiry.
+func (i *Issuer) Verify(token string) (Claims, error) {
+	switch i.alg {
+	case AlgHS256:
+		return VerifyHMAC(token, i.secret)
+	case AlgEdDSA:
+		return VerifyEd25519(token, i.edKey.Public().(ed25519.PublicKey))
+	}
+	return Claims{}, fmt.Errorf("linktoken: unknown alg %q", i.alg)
+}
+
+// VerifyHMAC checks an HS256 token against secret and its expiry.
+func VerifyHMAC(token string, secret []byte) (Claims, error) {
+	return verify(token, AlgHS256, func(signing string, sig []byte) bool {
+		return hmac.Equal(sig, hmacSHA256(secret, signing))
+	}, time.Now())
+}
+
+// VerifyEd25519 checks an EdDSA token against pub and its expiry.
+func VerifyEd25519(token string, pub ed25519.PublicKey) (Claims, error) {
+	return verify(token, AlgEdDSA, func(signing string, sig []byte) bool {
+		return ed25519.Verify(pub, []byte(signing), sig)
+	}, time.Now())
+}
+
+// ParseEd25519PrivateKey reads a PKCS#8 "PRIVATE KEY" PEM block.
+func ParseEd25519PrivateKey(raw []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("linktoken: no PEM block in private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("linktoken: %T is not an Ed25519 key", key)
+	}
+	return ed, nil
+}
+
+// ParseEd25519PublicKey reads a PKIX "PUBLIC KEY" PEM block, for the
+// download service side.
+func ParseEd25519PublicKey(raw []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("linktoken: no PEM block in public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ed, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("linktoken: %T is not an Ed25519 key", key)
+	}
+	return ed, nil
+}
+
+func verify(token, alg string, check func(signing string, sig []byte) bool, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	var h header
+	if err := dec(parts[0], &h); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	// alg ต้องตรงกับ key ที่ใช้ verify เสมอ กัน alg confusion
+	if h.Alg != alg {
+		return Claims{}, fmt.Errorf("linktoken: unexpected alg %q", h.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if !check(parts[0]+"."+parts[1], sig) {
+		return Claims{}, ErrSignature
+	}
+
+	var c Claims
+	if err := dec(parts[1], &c); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if c.ExpiresAt == 0 || now.Unix() >= c.ExpiresAt {
+		return c, ErrExpired
+	}
+	return c, nil
+}
+
+func hmacSHA256(secret []byte, s string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+func enc(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func dec(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
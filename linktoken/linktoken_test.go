@@ -0,0 +1,131 @@
+package linktoken
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func testEdKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, key
+}
+
+func TestRoundTrip(t *testing.T) {
+	pub, key := testEdKey(t)
+	cases := map[string]struct {
+		issuer *Issuer
+		verify func(string) (Claims, error)
+	}{
+		AlgHS256: {NewHMACIssuer(testSecret, time.Hour), func(tok string) (Claims, error) { return VerifyHMAC(tok, testSecret) }},
+		AlgEdDSA: {NewEd25519Issuer(key, time.Hour), func(tok string) (Claims, error) { return VerifyEd25519(tok, pub) }},
+	}
+	for alg, c := range cases {
+		tok, err := c.issuer.Issue("TRF001", "corp@example.com")
+		if err != nil {
+			t.Fatalf("%s: issue: %v", alg, err)
+		}
+		for name, verify := range map[string]func(string) (Claims, error){"Verify": c.issuer.Verify, "package": c.verify} {
+			claims, err := verify(tok)
+			if err != nil {
+				t.Errorf("%s %s: %v", alg, name, err)
+				continue
+			}
+			if claims.TransferId != "TRF001" || claims.Recipient != "corp@example.com" {
+				t.Errorf("%s %s: claims = %+v", alg, name, claims)
+			}
+			if claims.ExpiresAt-claims.IssuedAt != int64(time.Hour/time.Second) {
+				t.Errorf("%s %s: lifetime = %ds, want 3600s", alg, name, claims.ExpiresAt-claims.IssuedAt)
+			}
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	_, key := testEdKey(t)
+	for _, i := range []*Issuer{NewHMACIssuer(testSecret, time.Hour), NewEd25519Issuer(key, time.Hour)} {
+		i.nowFunc = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+		tok, err := i.Issue("TRF001", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := i.Verify(tok); !errors.Is(err, ErrExpired) {
+			t.Errorf("%s: err = %v, want ErrExpired", i.alg, err)
+		}
+	}
+}
+
+func TestTampered(t *testing.T) {
+	_, key := testEdKey(t)
+	for _, i := range []*Issuer{NewHMACIssuer(testSecret, time.Hour), NewEd25519Issuer(key, time.Hour)} {
+		tok, err := i.Issue("TRF001", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.Split(tok, ".")
+
+		p, _ := json.Marshal(Claims{TransferId: "TRF002", IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		payload := parts[0] + "." + enc(p) + "." + parts[2]
+		if _, err := i.Verify(payload); !errors.Is(err, ErrSignature) {
+			t.Errorf("%s: tampered payload err = %v, want ErrSignature", i.alg, err)
+		}
+
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sig[0] ^= 1
+		if _, err := i.Verify(parts[0] + "." + parts[1] + "." + enc(sig)); !errors.Is(err, ErrSignature) {
+			t.Errorf("%s: tampered signature err = %v, want ErrSignature", i.alg, err)
+		}
+
+		if _, err := i.Verify(parts[0] + "." + parts[1]); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%s: two-part token err = %v, want ErrMalformed", i.alg, err)
+		}
+	}
+}
+
+func TestAlgConfusion(t *testing.T) {
+	pub, key := testEdKey(t)
+
+	hs, err := NewHMACIssuer(testSecret, time.Hour).Issue("TRF001", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyEd25519(hs, pub); err == nil {
+		t.Error("HS256 token accepted by VerifyEd25519")
+	}
+
+	ed, err := NewEd25519Issuer(key, time.Hour).Issue("TRF001", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyHMAC(ed, testSecret); err == nil {
+		t.Error("EdDSA token accepted by VerifyHMAC")
+	}
+
+	// HMAC ที่ใช้ public key เป็น secret แต่อ้างว่าเป็นอีก alg ต้องไม่ผ่าน
+	forged := NewHMACIssuer(pub, time.Hour)
+	tok, err := forged.Issue("TRF001", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyEd25519(tok, pub); err == nil {
+		t.Error("HS256 token keyed with the Ed25519 public key accepted by VerifyEd25519")
+	}
+	h, _ := json.Marshal(header{Alg: AlgEdDSA, Typ: "JWT"})
+	parts := strings.Split(tok, ".")
+	signing := enc(h) + "." + parts[1]
+	relabeled := signing + "." + enc(hmacSHA256(pub, signing))
+	if _, err := VerifyEd25519(relabeled, pub); !errors.Is(err, ErrSignature) {
+		t.Errorf("EdDSA-labelled HMAC token err = %v, want ErrSignature", err)
+	}
+}
@@ -0,0 +1,186 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+const (
+	AuthAuto    = "auto"
+	AuthNone    = "none"
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+	AuthXOAUTH2 = "xoauth2"
+)
+
+// TokenProvider supplies the OAuth2 access token used by XOAUTH2. It is
+// called on every new session so a rotated token is picked up.
+type TokenProvider interface {
+	Token() (string, error)
+}
+
+type StaticToken string
+
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// FileToken re-reads the token from disk each time, for a sidecar that
+// refreshes it.
+type FileToken string
+
+func (f FileToken) Token() (string, error) {
+	b, err := os.ReadFile(string(f))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func parseAuthMech(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "":
+		return AuthAuto, nil
+	case AuthAuto, AuthNone, AuthPlain, AuthLogin, AuthCRAMMD5, AuthXOAUTH2:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown SMTP_AUTH %q", s)
+}
+
+func tokenProviderFromEnv() TokenProvider {
+	if path := os.Getenv("SMTP_OAUTH_TOKEN_FILE"); path != "" {
+		return FileToken(path)
+	}
+	if tok := os.Getenv("SMTP_OAUTH_TOKEN"); tok != "" {
+		return StaticToken(tok)
+	}
+	return nil
+}
+
+// authenticate picks a mechanism from the server's AUTH extension and the
+// configured SMTP_AUTH. With "auto" a server that advertises no AUTH, or a
+// config without credentials, is treated as an IP-allowlisted relay and no
+// authentication is sent.
+func (p *Pool) authenticate(client *smtp.Client) error {
+	cfg := p.cfg
+	if cfg.AuthMech == AuthNone {
+		return nil
+	}
+
+	ok, params := client.Extension("AUTH")
+	offered := map[string]bool{}
+	for _, m := range strings.Fields(strings.ToLower(params)) {
+		offered[m] = true
+	}
+
+	mech := cfg.AuthMech
+	if mech == AuthAuto {
+		if !ok || (cfg.User == "" && cfg.TokenProvider == nil) {
+			return nil
+		}
+		mech = ""
+		for _, m := range []string{AuthXOAUTH2, AuthCRAMMD5, AuthPlain, AuthLogin} {
+			if !offered[m] {
+				continue
+			}
+			if m == AuthXOAUTH2 && cfg.TokenProvider == nil {
+				continue
+			}
+			if m != AuthXOAUTH2 && cfg.User == "" {
+				continue
+			}
+			mech = m
+			break
+		}
+		if mech == "" {
+			return fmt.Errorf("smtp server offers AUTH %q but no usable credentials are configured", params)
+		}
+	} else if !offered[mech] {
+		return fmt.Errorf("smtp server does not offer AUTH %s", strings.ToUpper(mech))
+	}
+
+	_, isTLS := client.TLSConnectionState()
+	if !isTLS && mech != AuthCRAMMD5 && !cfg.AllowInsecureAuth {
+		return fmt.Errorf("refusing AUTH %s over an unencrypted connection", strings.ToUpper(mech))
+	}
+
+	var a smtp.Auth
+	switch mech {
+	case AuthPlain:
+		a = &plainAuth{user: cfg.User, pass: cfg.Pass}
+	case AuthLogin:
+		a = &loginAuth{user: cfg.User, pass: cfg.Pass}
+	case AuthCRAMMD5:
+		a = smtp.CRAMMD5Auth(cfg.User, cfg.Pass)
+	case AuthXOAUTH2:
+		if cfg.TokenProvider == nil {
+			return errors.New("AUTH XOAUTH2 needs SMTP_OAUTH_TOKEN or SMTP_OAUTH_TOKEN_FILE")
+		}
+		token, err := cfg.TokenProvider.Token()
+		if err != nil {
+			return fmt.Errorf("xoauth2 token: %w", err)
+		}
+		a = &xoauth2Auth{user: cfg.User, token: token}
+	}
+	return client.Auth(a)
+}
+
+// plainAuth is smtp.PlainAuth without its localhost/TLS check; the TLS
+// policy is enforced in authenticate instead.
+type plainAuth struct {
+	user, pass string
+}
+
+func (a *plainAuth) Start(*smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.user + "\x00" + a.pass), nil
+}
+
+func (a *plainAuth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("unexpected server challenge")
+	}
+	return nil, nil
+}
+
+type loginAuth struct {
+	user, pass string
+}
+
+func (a *loginAuth) Start(*smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(string(fromServer))
+	switch {
+	case strings.Contains(prompt, "username"):
+		return []byte(a.user), nil
+	case strings.Contains(prompt, "password"):
+		return []byte(a.pass), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+type xoauth2Auth struct {
+	user, token string
+}
+
+func (a *xoauth2Auth) Start(*smtp.ServerInfo) (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + a.user + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		// server ส่ง error เป็น JSON มา ต้องตอบว่างเพื่อให้ได้ 535 กลับมา
+		return []byte{}, nil
+	}
+	return nil, nil
+}
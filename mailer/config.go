@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"os"
+	"time"
+
+	"pond/env"
+)
+
+type Config struct {
+	Host        string
+	Port        string
+	User        string
+	Pass        string
+	PoolSize    int
+	IdleTimeout time.Duration
+	Timeout     time.Duration
+	TLSMode     TLSMode
+	CAFile      string
+	CertFile    string
+	KeyFile     string
+
+	AuthMech          string
+	TokenProvider     TokenProvider
+	AllowInsecureAuth bool
+
+	DKIM *DKIMSigner
+}
+
+// ConfigFromEnv reads the SMTP_* variables shared by every sender.
+func ConfigFromEnv() (Config, error) {
+	mode, err := ParseTLSMode(os.Getenv("SMTP_TLS_MODE"), os.Getenv("SMTP_PORT"))
+	if err != nil {
+		return Config{}, err
+	}
+	mech, err := parseAuthMech(os.Getenv("SMTP_AUTH"))
+	if err != nil {
+		return Config{}, err
+	}
+	dkim, err := DKIMSignerFromEnv()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return Config{
+		Host:        os.Getenv("SMTP_HOST"),
+		Port:        os.Getenv("SMTP_PORT"),
+		User:        os.Getenv("SMTP_USER"),
+		Pass:        os.Getenv("SMTP_PASS"),
+		PoolSize:    env.Int("SMTP_POOL_SIZE", 2),
+		IdleTimeout: env.Duration("SMTP_IDLE_TIMEOUT", 30*time.Second),
+		Timeout:     env.Duration("SMTP_TIMEOUT", 60*time.Second),
+		TLSMode:     mode,
+		CAFile:      os.Getenv("SMTP_CA_FILE"),
+		CertFile:    os.Getenv("SMTP_CLIENT_CERT"),
+		KeyFile:     os.Getenv("SMTP_CLIENT_KEY"),
+
+		AuthMech:          mech,
+		TokenProvider:     tokenProviderFromEnv(),
+		AllowInsecureAuth: os.Getenv("SMTP_ALLOW_INSECURE_AUTH") == "true",
+
+		DKIM: dkim,
+	}, nil
+}
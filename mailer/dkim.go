@@ -0,0 +1,259 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dkimHeaders are signed when present in the message.
+var dkimHeaders = []string{
+	"From", "To", "Cc", "Subject", "Date", "Message-ID", "Reply-To",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// DKIMSigner adds a relaxed/relaxed DKIM-Signature using rsa-sha256 or
+// ed25519-sha256 depending on the key type.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+}
+
+// DKIMSignerFromEnv loads DKIM_DOMAIN, DKIM_SELECTOR and
+// DKIM_PRIVATE_KEY_PATH. It returns nil when DKIM_DOMAIN is not set.
+func DKIMSignerFromEnv() (*DKIMSigner, error) {
+	domain := os.Getenv("DKIM_DOMAIN")
+	if domain == "" {
+		return nil, nil
+	}
+	selector := os.Getenv("DKIM_SELECTOR")
+	if selector == "" {
+		return nil, errors.New("DKIM_SELECTOR is required when DKIM_DOMAIN is set")
+	}
+
+	raw, err := os.ReadFile(os.Getenv("DKIM_PRIVATE_KEY_PATH"))
+	if err != nil {
+		return nil, fmt.Errorf("read DKIM_PRIVATE_KEY_PATH: %w", err)
+	}
+	key, err := parseDKIMKey(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DKIMSigner{Domain: domain, Selector: selector, Key: key}, nil
+}
+
+func parseDKIMKey(raw []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM block in private key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, nil
+		case ed25519.PrivateKey:
+			return k, nil
+		}
+		return nil, fmt.Errorf("dkim: unsupported key type %T", key)
+	}
+	return nil, fmt.Errorf("dkim: unsupported PEM block %q", block.Type)
+}
+
+func (s *DKIMSigner) algorithm() (string, error) {
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	}
+	return "", fmt.Errorf("dkim: unsupported key type %T", s.Key)
+}
+
+// Sign returns msg with CRLF line endings and a DKIM-Signature header
+// prepended.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	algo, err := s.algorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	msg = toCRLF(msg)
+	headers, body := splitMessage(msg)
+
+	bh := sha256.Sum256(relaxedBody(body))
+
+	var signed []string
+	for _, name := range dkimHeaders {
+		if _, ok := findHeader(headers, name, nil); ok {
+			signed = append(signed, strings.ToLower(name))
+		}
+	}
+
+	sig := "DKIM-Signature: v=1; a=" + algo + "; c=relaxed/relaxed;\r\n" +
+		"\td=" + s.Domain + "; s=" + s.Selector + ";\r\n" +
+		"\tt=" + strconv.FormatInt(time.Now().Unix(), 10) + ";\r\n" +
+		"\th=" + strings.Join(signed, ":") + ";\r\n" +
+		"\tbh=" + base64.StdEncoding.EncodeToString(bh[:]) + ";\r\n" +
+		"\tb="
+
+	digest := sha256.Sum256(dkimSignedData(headers, signed, sig))
+
+	var b []byte
+	switch key := s.Key.(type) {
+	case *rsa.PrivateKey:
+		b, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case ed25519.PrivateKey:
+		b = ed25519.Sign(key, digest[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sig)
+	out.WriteString(foldBase64(base64.StdEncoding.EncodeToString(b)))
+	out.WriteString("\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+// dkimSignedData is the header hash input: each signed header in relaxed
+// form, bottom-up for repeated names, followed by the DKIM-Signature header
+// itself with an empty b= and no trailing CRLF.
+func dkimSignedData(headers, signed []string, sig string) []byte {
+	var buf bytes.Buffer
+	used := map[int]bool{}
+	for _, name := range signed {
+		h, ok := findHeader(headers, name, used)
+		if !ok {
+			continue
+		}
+		buf.WriteString(relaxedHeader(h))
+	}
+	buf.WriteString(strings.TrimSuffix(relaxedHeader(sig), "\r\n"))
+	return buf.Bytes()
+}
+
+func toCRLF(msg []byte) []byte {
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+}
+
+// splitMessage returns the raw header fields (continuation lines included)
+// and the body.
+func splitMessage(msg []byte) ([]string, []byte) {
+	head, body := msg, []byte(nil)
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		head, body = msg[:i+2], msg[i+4:]
+	}
+
+	var headers []string
+	for _, line := range strings.SplitAfter(string(head), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1] += line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, body
+}
+
+// findHeader returns the last header named name that is not in used.
+func findHeader(headers []string, name string, used map[int]bool) (string, bool) {
+	for i := len(headers) - 1; i >= 0; i-- {
+		if used[i] {
+			continue
+		}
+		k, _, ok := strings.Cut(headers[i], ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			if used != nil {
+				used[i] = true
+			}
+			return headers[i], true
+		}
+	}
+	return "", false
+}
+
+func relaxedHeader(h string) string {
+	k, v, _ := strings.Cut(h, ":")
+	v = strings.NewReplacer("\r\n", "").Replace(v)
+	return strings.ToLower(strings.TrimSpace(k)) + ":" + collapseWSP(v) + "\r\n"
+}
+
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(collapseWSPKeep(l), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWSP collapses runs of WSP to one space and trims both ends.
+func collapseWSP(s string) string {
+	return strings.TrimSpace(collapseWSPKeep(s))
+}
+
+func collapseWSPKeep(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// foldBase64 breaks a long b= value over continuation lines.
+func foldBase64(s string) string {
+	const width = 72
+	var b strings.Builder
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteString("\r\n\t")
+		s = s[width:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
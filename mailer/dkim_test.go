@@ -0,0 +1,244 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testMessage = "From: Report <no-reply@thaidotcompayment.co.th>\r\n" +
+	"To: corp@example.com\r\n" +
+	"Subject: Daily transfer report\r\n" +
+	"Date: Sat, 18 Oct 2026 09:00:00 +0700\r\n" +
+	"Message-ID: <1@thaidotcompayment.co.th>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Hello,\r\n" +
+	"your report is ready.\r\n"
+
+func testSigners(t *testing.T) map[string]struct {
+	signer *DKIMSigner
+	pub    crypto.PublicKey
+} {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]struct {
+		signer *DKIMSigner
+		pub    crypto.PublicKey
+	}{
+		"rsa-sha256":     {&DKIMSigner{Domain: "thaidotcompayment.co.th", Selector: "s1", Key: rsaKey}, &rsaKey.PublicKey},
+		"ed25519-sha256": {&DKIMSigner{Domain: "thaidotcompayment.co.th", Selector: "s2", Key: edKey}, edPub},
+	}
+}
+
+func TestDKIMRoundTrip(t *testing.T) {
+	for algo, s := range testSigners(t) {
+		signed, err := s.signer.Sign([]byte(testMessage))
+		if err != nil {
+			t.Fatalf("%s: sign: %v", algo, err)
+		}
+		if !bytes.Contains(signed, []byte("a="+algo+";")) {
+			t.Errorf("%s: signature header does not name the algorithm:\n%s", algo, signed)
+		}
+		if err := verifyDKIM(signed, s.pub); err != nil {
+			t.Errorf("%s: verify: %v", algo, err)
+		}
+	}
+}
+
+func TestDKIMTampered(t *testing.T) {
+	cases := []struct {
+		name string
+		old  string
+		new  string
+	}{
+		{"body", "your report is ready.", "your report is not ready."},
+		{"header", "Subject: Daily transfer report", "Subject: Daily transfer rep0rt"},
+	}
+	for algo, s := range testSigners(t) {
+		signed, err := s.signer.Sign([]byte(testMessage))
+		if err != nil {
+			t.Fatalf("%s: sign: %v", algo, err)
+		}
+		for _, c := range cases {
+			msg := strings.Replace(string(signed), c.old, c.new, 1)
+			if err := verifyDKIM([]byte(msg), s.pub); err == nil {
+				t.Errorf("%s: tampered %s still verifies", algo, c.name)
+			}
+		}
+	}
+}
+
+// Relaxed canonicalization must survive what relays typically do: refold
+// headers, change whitespace runs and add trailing spaces or empty lines.
+func TestDKIMRelaxedSurvivesWhitespace(t *testing.T) {
+	for algo, s := range testSigners(t) {
+		signed, err := s.signer.Sign([]byte(testMessage))
+		if err != nil {
+			t.Fatalf("%s: sign: %v", algo, err)
+		}
+		msg := string(signed)
+		msg = strings.Replace(msg, "Subject: Daily transfer report", "subject:   Daily\r\n\ttransfer  report ", 1)
+		msg = strings.Replace(msg, "Hello,\r\n", "Hello, \t\r\n", 1)
+		msg = strings.Replace(msg, "your report is ready.", "your  report\tis ready.", 1)
+		msg += "\r\n\r\n"
+		if err := verifyDKIM([]byte(msg), s.pub); err != nil {
+			t.Errorf("%s: verify after whitespace changes: %v", algo, err)
+		}
+	}
+}
+
+func TestRelaxedHeader(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"Subject: Daily report\r\n", "subject:Daily report\r\n"},
+		{"SUBJECT :  Daily \t report  \r\n", "subject:Daily report\r\n"},
+		{"Subject: Daily\r\n\treport\r\n", "subject:Daily report\r\n"},
+		{"To: a@example.com,\r\n  b@example.com\r\n", "to:a@example.com, b@example.com\r\n"},
+	}
+	for _, c := range cases {
+		if got := relaxedHeader(c.in); got != c.want {
+			t.Errorf("relaxedHeader(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRelaxedBody(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"line \t\r\n", "line\r\n"},
+		{"a  b\tc\r\n", "a b c\r\n"},
+		{"text\r\n\r\n\r\n", "text\r\n"},
+		{" lead\r\n", " lead\r\n"},
+		{"\r\n\r\n", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if got := string(relaxedBody([]byte(c.in))); got != c.want {
+			t.Errorf("relaxedBody(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+// verifyDKIM checks the DKIM-Signature of msg against pub.
+func verifyDKIM(msg []byte, pub crypto.PublicKey) error {
+	msg = toCRLF(msg)
+	headers, body := splitMessage(msg)
+
+	sig, ok := findHeader(headers, "DKIM-Signature", nil)
+	if !ok {
+		return errors.New("dkim: no DKIM-Signature header")
+	}
+	tags := parseTags(headerValue(sig))
+
+	if tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("dkim: unsupported canonicalization %q", tags["c"])
+	}
+
+	bh := sha256.Sum256(relaxedBody(body))
+	if base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return errors.New("dkim: body hash mismatch")
+	}
+
+	b, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("dkim: bad b= tag: %w", err)
+	}
+
+	var signed []string
+	for _, h := range strings.Split(tags["h"], ":") {
+		signed = append(signed, strings.TrimSpace(h))
+	}
+
+	// ตัดค่าหลัง b= ออก เหลือ header ในรูปที่ถูก sign
+	i := bTagIndex(sig)
+	if i < 0 {
+		return errors.New("dkim: no b= tag")
+	}
+	unsigned := sig[:i+2]
+	if j := strings.Index(sig[i:], ";"); j >= 0 {
+		unsigned += sig[i+j:]
+	}
+	unsigned = strings.TrimSuffix(unsigned, "\r\n")
+
+	rest := make([]string, 0, len(headers))
+	for _, h := range headers {
+		if h != sig {
+			rest = append(rest, h)
+		}
+	}
+	digest := sha256.Sum256(dkimSignedData(rest, signed, unsigned))
+
+	switch tags["a"] {
+	case "rsa-sha256":
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("dkim: rsa-sha256 signature but %T key", pub)
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], b)
+	case "ed25519-sha256":
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("dkim: ed25519-sha256 signature but %T key", pub)
+		}
+		if !ed25519.Verify(key, digest[:], b) {
+			return errors.New("dkim: signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("dkim: unsupported algorithm %q", tags["a"])
+}
+
+func headerValue(h string) string {
+	_, v, _ := strings.Cut(h, ":")
+	return v
+}
+
+// bTagIndex finds the b= tag, skipping "b=" inside other tag values.
+func bTagIndex(h string) int {
+	for i := 0; i+1 < len(h); i++ {
+		if h[i] != 'b' || h[i+1] != '=' {
+			continue
+		}
+		j := i - 1
+		for j >= 0 && strings.ContainsRune(" \t\r\n", rune(h[j])) {
+			j--
+		}
+		if j >= 0 && (h[j] == ';' || h[j] == ':') {
+			return i
+		}
+	}
+	return -1
+}
+
+func parseTags(v string) map[string]string {
+	tags := map[string]string{}
+	for _, part := range strings.Split(v, ";") {
+		k, val, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		val = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, val)
+		tags[strings.TrimSpace(k)] = val
+	}
+	return tags
+}
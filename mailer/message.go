@@ -0,0 +1,296 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	EncodingQuotedPrintable = "quoted-printable"
+	EncodingBase64          = "base64"
+
+	defaultMessageIDDomain = "thaidotcompayment.co.th"
+	maxHeaderLine          = 78
+)
+
+// Message is a report or notification email. Bytes renders it as
+// multipart/alternative with a text/plain part derived from HTML when Text
+// is empty, wrapped in multipart/mixed when there are attachments.
+type Message struct {
+	ReturnPath  string
+	From        mail.Address
+	To          []mail.Address
+	Subject     string
+	HTML        string
+	Text        string
+	Encoding    string // quoted-printable (default) | base64
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+func (m *Message) Bytes() ([]byte, error) {
+	if err := m.validateHeaders(); err != nil {
+		return nil, err
+	}
+
+	enc := m.Encoding
+	if enc == "" {
+		enc = EncodingQuotedPrintable
+	}
+	if enc != EncodingQuotedPrintable && enc != EncodingBase64 {
+		return nil, fmt.Errorf("unknown body encoding %q", enc)
+	}
+
+	text := m.Text
+	if text == "" {
+		text = HTMLToText(m.HTML)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := writePart(mw, "text/plain; charset=UTF-8", enc, text); err != nil {
+		return nil, err
+	}
+	if err := writePart(mw, "text/html; charset=UTF-8", enc, m.HTML); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	contentType := "multipart/alternative; boundary=\"" + mw.Boundary() + "\""
+
+	if len(m.Attachments) > 0 {
+		alt := body.Bytes()
+		var mixed bytes.Buffer
+		xw := multipart.NewWriter(&mixed)
+
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", contentType)
+		w, err := xw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(alt); err != nil {
+			return nil, err
+		}
+
+		for _, a := range m.Attachments {
+			if err := writeAttachment(xw, a); err != nil {
+				return nil, err
+			}
+		}
+		if err := xw.Close(); err != nil {
+			return nil, err
+		}
+
+		body = mixed
+		contentType = "multipart/mixed; boundary=\"" + xw.Boundary() + "\""
+	}
+
+	to := make([]string, len(m.To))
+	for i, a := range m.To {
+		to[i] = a.String()
+	}
+
+	var buf bytes.Buffer
+	if m.ReturnPath != "" {
+		writeHeader(&buf, "Return-Path", "<"+m.ReturnPath+">")
+	}
+	writeHeader(&buf, "From", m.From.String())
+	writeHeader(&buf, "To", strings.Join(to, ", "))
+	writeHeader(&buf, "Subject", encodeHeaderText(m.Subject))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", newMessageID(m.From.Address))
+	writeHeader(&buf, "MIME-Version", "1.0")
+	writeHeader(&buf, "Content-Type", contentType)
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// validateHeaders rejects CR, LF and other control characters in every
+// value that ends up in a header line.
+func (m *Message) validateHeaders() error {
+	type field struct{ name, value string }
+	fields := []field{
+		{"Return-Path", m.ReturnPath},
+		{"From", m.From.Name},
+		{"From", m.From.Address},
+		{"Subject", m.Subject},
+	}
+	for _, a := range m.To {
+		fields = append(fields, field{"To", a.Name}, field{"To", a.Address})
+	}
+	for _, a := range m.Attachments {
+		fields = append(fields, field{"Content-Disposition", a.Filename})
+	}
+
+	for _, f := range fields {
+		if err := CheckHeaderValue(f.value); err != nil {
+			return fmt.Errorf("header %s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// CheckHeaderValue fails when v contains CR, LF or another control
+// character that could be used to inject header lines.
+func CheckHeaderValue(v string) error {
+	for _, r := range v {
+		if r == '\r' || r == '\n' || (r < 0x20 && r != '\t') || r == 0x7f {
+			return fmt.Errorf("control character %q not allowed", r)
+		}
+	}
+	return nil
+}
+
+func writePart(mw *multipart.Writer, contentType, enc, content string) error {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Transfer-Encoding", enc)
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	return encodeBody(w, enc, []byte(content))
+}
+
+func writeAttachment(mw *multipart.Writer, a Attachment) error {
+	ct := a.ContentType
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mime.FormatMediaType(ct, map[string]string{"name": a.Filename}))
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	h.Set("Content-Transfer-Encoding", EncodingBase64)
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	return encodeBody(w, EncodingBase64, a.Data)
+}
+
+// encodeBody writes content with lines no longer than 76 characters.
+func encodeBody(w io.Writer, enc string, content []byte) error {
+	if enc == EncodingBase64 {
+		s := base64.StdEncoding.EncodeToString(content)
+		for len(s) > 76 {
+			if _, err := io.WriteString(w, s[:76]+"\r\n"); err != nil {
+				return err
+			}
+			s = s[76:]
+		}
+		_, err := io.WriteString(w, s+"\r\n")
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(content); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// encodeHeaderText turns non-ASCII or over-long text into RFC 2047
+// encoded-words so gateways do not mangle Thai subjects.
+func encodeHeaderText(s string) string {
+	if isPlainASCII(s) && len(s) <= maxHeaderLine-len("Subject: ") {
+		return s
+	}
+	return mime.BEncoding.Encode("UTF-8", s)
+}
+
+func isPlainASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf || s[i] < ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// writeHeader folds value at spaces so no line exceeds 78 characters where
+// possible (and never 998).
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	line := name + ":"
+	for i, word := range strings.Split(value, " ") {
+		if i > 0 && len(line)+1+len(word) > maxHeaderLine {
+			buf.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+func newMessageID(from string) string {
+	domain := defaultMessageIDDomain
+	if i := strings.LastIndex(from, "@"); i >= 0 && i+1 < len(from) {
+		domain = from[i+1:]
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain)
+}
+
+var (
+	reDropBlock = regexp.MustCompile(`(?is)<(style|script|head)[^>]*>.*?</(style|script|head)>`)
+	reLink      = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	reBreak     = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|h[1-6]|ol|ul)>`)
+	reListItem  = regexp.MustCompile(`(?i)<li[^>]*>`)
+	reTag       = regexp.MustCompile(`(?s)<[^>]*>`)
+	reSpaces    = regexp.MustCompile(`[ \t]+`)
+	reBlank     = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText derives the text/plain alternative from an HTML body.
+func HTMLToText(s string) string {
+	s = reDropBlock.ReplaceAllString(s, "")
+	s = reLink.ReplaceAllStringFunc(s, func(a string) string {
+		m := reLink.FindStringSubmatch(a)
+		label := strings.TrimSpace(reTag.ReplaceAllString(m[2], ""))
+		if label == "" || label == m[1] {
+			return m[1]
+		}
+		return label + " (" + m[1] + ")"
+	})
+	s = strings.NewReplacer("\r\n", " ", "\n", " ").Replace(s)
+	s = reBreak.ReplaceAllString(s, "\n")
+	s = reListItem.ReplaceAllString(s, "\n- ")
+	s = reTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(reSpaces.ReplaceAllString(l, " "))
+	}
+	s = strings.Join(lines, "\n")
+	s = reBlank.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s) + "\n"
+}
@@ -0,0 +1,304 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrAfterData marks a failure once the server has accepted DATA. The
+// message may already be queued, so it must not be sent again.
+var ErrAfterData = errors.New("smtp: failed after DATA was accepted, message may be queued")
+
+// Pool keeps up to PoolSize authenticated SMTP sessions open and reuses
+// them across messages. Sessions idle for longer than IdleTimeout are closed.
+type Pool struct {
+	cfg   Config
+	tls   *tls.Config
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*session
+	closed bool
+	stop   chan struct{}
+}
+
+type session struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func NewPool(cfg Config) (*Pool, error) {
+	tc, err := buildTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pool{
+		cfg:   cfg,
+		tls:   tc,
+		slots: make(chan struct{}, cfg.PoolSize),
+		stop:  make(chan struct{}),
+	}
+	go p.reapIdle()
+	return p, nil
+}
+
+func (p *Pool) Config() Config {
+	return p.cfg
+}
+
+// Send delivers msg to rcpts, DKIM-signing it first when configured. A
+// pooled session that turns out to be dead (421, broken pipe, reset) before
+// DATA is accepted is dropped and the message is retried once on a fresh
+// connection. Later failures wrap ErrAfterData and are not retried.
+func (p *Pool) Send(from string, rcpts []string, msg []byte) error {
+	_, err := p.SendWithResponse(from, rcpts, msg)
+	return err
+}
+
+// SendWithResponse is Send that also returns the server's reply to the end
+// of DATA, e.g. "250 2.0.0 Ok: queued as 4F1A2", for delivery records.
+func (p *Pool) SendWithResponse(from string, rcpts []string, msg []byte) (string, error) {
+	if p.cfg.DKIM != nil {
+		signed, err := p.cfg.DKIM.Sign(msg)
+		if err != nil {
+			return "", err
+		}
+		msg = signed
+	}
+
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	s, reused, err := p.get()
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := p.transact(s, from, rcpts, msg)
+	if err != nil && reused && isConnError(err) && !errors.Is(err, ErrAfterData) {
+		log.Printf("[SMTP] pooled session broken, reconnecting: %v", err)
+		s.close()
+		if s, err = p.dial(); err != nil {
+			return "", err
+		}
+		reply, err = p.transact(s, from, rcpts, msg)
+	}
+
+	if err != nil && isConnError(err) {
+		s.close()
+		return reply, err
+	}
+	p.put(s)
+	return reply, err
+}
+
+// Close quits every idle session and stops the idle reaper.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	for _, s := range idle {
+		s.quit()
+	}
+}
+
+func (p *Pool) get() (*session, bool, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		s := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if time.Since(s.lastUsed) > p.cfg.IdleTimeout {
+			s.quit()
+			continue
+		}
+		// RSET ล้าง transaction เก่าและเช็คว่า session ยังใช้ได้
+		s.conn.SetDeadline(time.Now().Add(p.cfg.Timeout))
+		if err := s.client.Reset(); err != nil {
+			s.close()
+			continue
+		}
+		return s, true, nil
+	}
+
+	s, err := p.dial()
+	return s, false, err
+}
+
+func (p *Pool) put(s *session) {
+	s.lastUsed = time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		go s.quit()
+		return
+	}
+	p.idle = append(p.idle, s)
+}
+
+func (p *Pool) dial() (*session, error) {
+	cfg := p.cfg
+
+	addr := net.JoinHostPort(cfg.Host, cfg.Port)
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+
+	var conn net.Conn
+	var err error
+	if cfg.TLSMode == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, p.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(cfg.Timeout))
+
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := &session{conn: conn, client: client}
+
+	if cfg.TLSMode == TLSStartTLSOptional || cfg.TLSMode == TLSStartTLSRequired {
+		ok, _ := client.Extension("STARTTLS")
+		if !ok && cfg.TLSMode == TLSStartTLSRequired {
+			s.quit()
+			return nil, fmt.Errorf("smtp server %s does not offer STARTTLS", addr)
+		}
+		if ok {
+			if err := client.StartTLS(p.tls); err != nil {
+				s.close()
+				return nil, err
+			}
+		}
+	}
+
+	if err := p.authenticate(client); err != nil {
+		s.quit()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// transact runs MAIL, RCPT and DATA. DATA goes through client.Text instead
+// of client.Data so the final reply can be returned.
+func (p *Pool) transact(s *session, from string, rcpts []string, msg []byte) (string, error) {
+	s.conn.SetDeadline(time.Now().Add(p.cfg.Timeout))
+
+	if err := s.client.Mail(from); err != nil {
+		return "", err
+	}
+	for _, addr := range rcpts {
+		if err := s.client.Rcpt(addr); err != nil {
+			return "", err
+		}
+	}
+
+	text := s.client.Text
+	id, err := text.Cmd("DATA")
+	if err != nil {
+		return "", err
+	}
+	text.StartResponse(id)
+	_, _, err = text.ReadResponse(354)
+	text.EndResponse(id)
+	if err != nil {
+		return "", err
+	}
+
+	// ตั้งแต่ตรงนี้ server อาจรับเมลไปแล้ว ส่งซ้ำไม่ได้
+	w := text.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return "", fmt.Errorf("%w: %w", ErrAfterData, err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrAfterData, err)
+	}
+	code, reply, err := text.ReadResponse(250)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrAfterData, err)
+	}
+	return fmt.Sprintf("%d %s", code, reply), nil
+}
+
+func (p *Pool) reapIdle() {
+	ticker := time.NewTicker(p.cfg.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		var keep, expired []*session
+		for _, s := range p.idle {
+			if time.Since(s.lastUsed) > p.cfg.IdleTimeout {
+				expired = append(expired, s)
+			} else {
+				keep = append(keep, s)
+			}
+		}
+		p.idle = keep
+		p.mu.Unlock()
+
+		for _, s := range expired {
+			s.quit()
+		}
+	}
+}
+
+func (s *session) quit() {
+	s.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	s.client.Quit()
+	s.conn.Close()
+}
+
+func (s *session) close() {
+	s.client.Close()
+	s.conn.Close()
+}
+
+func isConnError(err error) bool {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code == 421
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
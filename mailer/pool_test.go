@@ -0,0 +1,125 @@
+package mailer
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts every command. After the end of DATA it replies 250 to
+// the first message of each connection and hangs up on the rest.
+type fakeSMTP struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	data int
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSMTP{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	messages := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250 fake")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+			}
+			f.mu.Lock()
+			f.data++
+			f.mu.Unlock()
+			messages++
+			if messages > 1 {
+				return
+			}
+			reply("250 2.0.0 Ok: queued as 4F1A2")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (f *fakeSMTP) dataCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.data
+}
+
+func TestSendDoesNotResendAfterData(t *testing.T) {
+	f := startFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(f.ln.Addr().String())
+	p, err := NewPool(Config{
+		Host:        host,
+		Port:        port,
+		PoolSize:    1,
+		IdleTimeout: time.Minute,
+		Timeout:     2 * time.Second,
+		TLSMode:     TLSNone,
+		AuthMech:    AuthNone,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	msg := []byte("Subject: test\r\n\r\nhello\r\n")
+	reply, err := p.SendWithResponse("a@example.com", []string{"b@example.com"}, msg)
+	if err != nil {
+		t.Fatalf("first send: %v", err)
+	}
+	if reply != "250 2.0.0 Ok: queued as 4F1A2" {
+		t.Errorf("reply = %q", reply)
+	}
+
+	// session ที่ pool ไว้ตัดสายหลังจบ DATA ต้องไม่ส่งซ้ำผ่าน connection ใหม่
+	_, err = p.SendWithResponse("a@example.com", []string{"b@example.com"}, msg)
+	if !errors.Is(err, ErrAfterData) {
+		t.Fatalf("second send err = %v, want ErrAfterData", err)
+	}
+	if n := f.dataCount(); n != 2 {
+		t.Errorf("server got %d messages, want 2", n)
+	}
+}
@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+type TLSMode string
+
+const (
+	TLSNone             TLSMode = "none"
+	TLSStartTLSOptional TLSMode = "starttls-optional"
+	TLSStartTLSRequired TLSMode = "starttls-required"
+	TLSImplicit         TLSMode = "implicit"
+)
+
+// ParseTLSMode maps SMTP_TLS_MODE to a TLSMode. An empty value keeps the
+// old opportunistic STARTTLS behaviour, except on port 465 where the relay
+// expects implicit TLS.
+func ParseTLSMode(s, port string) (TLSMode, error) {
+	switch TLSMode(s) {
+	case "":
+		if port == "465" {
+			return TLSImplicit, nil
+		}
+		return TLSStartTLSOptional, nil
+	case TLSNone, TLSStartTLSOptional, TLSStartTLSRequired, TLSImplicit:
+		return TLSMode(s), nil
+	}
+	return "", fmt.Errorf("unknown SMTP_TLS_MODE %q", s)
+}
+
+// buildTLSConfig loads the optional CA bundle and client certificate.
+func buildTLSConfig(cfg Config) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName: cfg.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read SMTP_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load SMTP client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
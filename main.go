@@ -3,29 +3,21 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
+	c "pond/controllers"
 	"pond/database"
 	r "pond/routes"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
 )
 
 func initDatabase() {
 
-	dsn := fmt.Sprintf(
-		"%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&loc=Local",
-		os.Getenv("DATABASE_USER"),
-		"",
-		os.Getenv("DATABASE_HOST"),
-		os.Getenv("DATABASE_PORT"),
-		os.Getenv("DATABASE_NAME"),
-	)
-	var err error
-	database.DBConn, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
-	if err != nil {
+	if err := database.Open(); err != nil {
+		panic(err)
+	}
+	if err := database.Migrate(); err != nil {
 		panic(err)
 	}
 	fmt.Println("Database connected!")
@@ -35,6 +27,25 @@ func main() {
 		log.Println("No .env file found")
 	}
 	initDatabase()
+	// Init* อ่านค่าจาก env ต้องเรียกหลังโหลด .env แล้ว
+	if err := c.InitMailer(); err != nil {
+		panic(err)
+	}
+	if err := c.InitTokenProvider(); err != nil {
+		panic(err)
+	}
+	if err := c.InitShortener(); err != nil {
+		panic(err)
+	}
+	if err := c.LoadTemplates(); err != nil {
+		panic(err)
+	}
+	// ต้องจับเวลาก่อน Listen ไม่งั้นงานของ request ใหม่จะถูก resume ซ้ำ
+	startedAt := time.Now()
+	go func() {
+		c.ResumeOutbox(startedAt)
+		c.ResumeJobs(startedAt)
+	}()
 	app := fiber.New()
 	r.Routesja(app)
 	app.Listen(":8888")
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"pond/database"
+)
+
+// Recipient is the corporation row in the info table.
+type Recipient struct {
+	Name      string `gorm:"column:NAME"`
+	Email     string `gorm:"column:EMAIL"`
+	AttachPdf bool   `gorm:"column:ATTACH_PDF"`
+	// LANG is "th" or "en"; BUDDHIST_ERA prints years as พ.ศ.
+	Lang        string `gorm:"column:LANG"`
+	BuddhistEra bool   `gorm:"column:BUDDHIST_ERA"`
+}
+
+// info เป็นตารางของระบบอื่น คอลัมน์เหล่านี้อาจยังไม่ได้เพิ่ม
+// (ดู database/sql/info_recipient_columns.sql)
+var optionalInfoColumns = []string{"ATTACH_PDF", "LANG", "BUDDHIST_ERA"}
+
+var (
+	infoColumnsMu sync.Mutex
+	infoColumns   map[string]bool
+)
+
+// infoHasColumn reports whether the info table has col. The column list is
+// read once and kept.
+func infoHasColumn(col string) (bool, error) {
+	infoColumnsMu.Lock()
+	defer infoColumnsMu.Unlock()
+
+	if infoColumns == nil {
+		types, err := database.DBConn.Migrator().ColumnTypes("info")
+		if err != nil {
+			return false, fmt.Errorf("read info columns: %w", err)
+		}
+		cols := map[string]bool{}
+		for _, t := range types {
+			cols[strings.ToUpper(t.Name())] = true
+		}
+		infoColumns = cols
+	}
+	return infoColumns[col], nil
+}
+
+// FindRecipient returns the info row for transferId, narrowed to
+// recipientId when one is given. A missing row is not an error; the caller
+// gets an empty Recipient. Optional columns the table does not have yet are
+// left at their zero value.
+func FindRecipient(transferId, recipientId string) (Recipient, error) {
+	cols := []string{"NAME", "EMAIL"}
+	for _, c := range optionalInfoColumns {
+		ok, err := infoHasColumn(c)
+		if err != nil {
+			return Recipient{}, err
+		}
+		if ok {
+			cols = append(cols, c)
+		}
+	}
+
+	q := database.DBConn.Table("info").
+		Select(cols).
+		Where("TRANSFER_ID = ?", transferId)
+	if recipientId != "" {
+		ok, err := infoHasColumn("RECIPIENT_ID")
+		if err != nil {
+			return Recipient{}, err
+		}
+		if !ok {
+			return Recipient{}, fmt.Errorf("recipient_id %q given but info has no RECIPIENT_ID column", recipientId)
+		}
+		q = q.Where("RECIPIENT_ID = ?", recipientId)
+	}
+
+	var r Recipient
+	err := q.Scan(&r).Error
+	return r, err
+}
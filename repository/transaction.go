@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"time"
+
+	"pond/database"
+)
+
+// TxnSummary is the aggregate of one transfer's transactions in a period.
+type TxnSummary struct {
+	TxnCount int64
+	// TxnAmount is SUM(AMOUNT) as MySQL prints the DECIMAL, so no float
+	// rounding happens on the way to the email.
+	TxnAmount string
+}
+
+// SummarizeTransactions counts and sums the transactions of transferId with
+// TXN_DATETIME in [from, to).
+func SummarizeTransactions(transferId string, from, to time.Time) (TxnSummary, error) {
+	var row struct {
+		TxnCount  int64  `gorm:"column:txn_count"`
+		TxnAmount string `gorm:"column:txn_amount"`
+	}
+
+	err := database.DBConn.Table("transactions").
+		Select("COUNT(*) AS txn_count, "+
+			"CAST(COALESCE(SUM(AMOUNT), 0) AS CHAR) AS txn_amount").
+		Where("TRANSFER_ID = ? AND TXN_DATETIME >= ? AND TXN_DATETIME < ?", transferId, from, to).
+		Scan(&row).Error
+	if err != nil {
+		return TxnSummary{}, err
+	}
+
+	return TxnSummary{
+		TxnCount:  row.TxnCount,
+		TxnAmount: row.TxnAmount,
+	}, nil
+}
@@ -7,7 +7,13 @@ import (
 )
 
 func Routesja(app *fiber.App) {
-	app.Post("/SMTP", c.HandleAPI)
+	app.Post("/SMTP", c.VerifySignature, c.HandleAPI)
+	app.Post("/SMTP/resend", c.VerifySignature, c.RequireAPIClient, c.ResendMail)
+	app.Get("/SMTP/jobs/:id", c.RequireAPIClient, c.GetJob)
+	app.Get("/SMTP/deliveries", c.RequireAPIClient, c.SearchDeliveries)
+	app.Get("/SMTP/deliveries/export", c.RequireAPIClient, c.ExportDeliveries)
+	app.Get("/SMTP/links/:transferId", c.RequireAPIClient, c.GetLinkClicks)
+	app.Get("/s/:code", c.RedirectShortLink)
 	// app.Post("/send_smtp_report", c.SendSMTPReport)
 
 }
@@ -0,0 +1,88 @@
+package shortlink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"pond/env"
+)
+
+// HTTP is the external short-link service.
+type HTTP struct {
+	URL      string
+	Client   *http.Client
+	Attempts int
+	Backoff  time.Duration
+}
+
+// NewHTTP reads SHORTLINK_TIMEOUT (default 10s), SHORTLINK_ATTEMPTS
+// (default 3) and SHORTLINK_BACKOFF (default 1s).
+func NewHTTP(url string) *HTTP {
+	return &HTTP{
+		URL:      url,
+		Client:   &http.Client{Timeout: env.Duration("SHORTLINK_TIMEOUT", 10*time.Second)},
+		Attempts: env.Int("SHORTLINK_ATTEMPTS", 3),
+		Backoff:  env.Duration("SHORTLINK_BACKOFF", time.Second),
+	}
+}
+
+func (h *HTTP) Shorten(ctx context.Context, req Request) (string, error) {
+	body, err := json.Marshal(map[string]string{"link": req.FullURL})
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 1; ; attempt++ {
+		var short string
+		short, err = h.post(ctx, body)
+		if err == nil {
+			return short, nil
+		}
+		if attempt >= h.Attempts {
+			return "", err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(h.Backoff):
+		}
+	}
+}
+
+func (h *HTTP) post(ctx context.Context, body []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("short-link service returned %s", resp.Status)
+	}
+
+	var res struct {
+		Data struct {
+			ShortLink string `json:"short-link"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if res.Data.ShortLink == "" {
+		return "", errors.New("short-link service returned an empty link")
+	}
+	return res.Data.ShortLink, nil
+}
@@ -0,0 +1,115 @@
+package shortlink
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"pond/database"
+
+	"gorm.io/gorm"
+)
+
+const (
+	codeAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	codeLength   = 8
+)
+
+var (
+	// ErrNotFound is returned by Resolve for an unknown code.
+	ErrNotFound = errors.New("short link not found")
+	// ErrExpired is returned by Resolve for a code past its ExpiresAt.
+	ErrExpired = errors.New("short link expired")
+)
+
+// Local stores codes in the short_link table and relies on this app's
+// /s/:code route to redirect them. Links expire after TTL; zero means never.
+type Local struct {
+	BaseURL string
+	TTL     time.Duration
+}
+
+// Link is a resolved short code.
+type Link struct {
+	Code       string
+	FullURL    string
+	TransferId string
+}
+
+func (l *Local) Shorten(ctx context.Context, req Request) (string, error) {
+	for i := 0; i < 3; i++ {
+		code, err := newCode()
+		if err != nil {
+			return "", err
+		}
+		row := database.ShortLink{Code: code, FullUrl: req.FullURL, TransferId: req.TransferId}
+		if l.TTL > 0 {
+			expires := time.Now().Add(l.TTL)
+			row.ExpiresAt = &expires
+		}
+		err = database.DBConn.WithContext(ctx).Create(&row).Error
+		if database.IsDuplicateKey(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(l.BaseURL, "/") + "/s/" + code, nil
+	}
+	return "", errors.New("could not allocate a unique short code")
+}
+
+// Resolve returns the link stored for code.
+func Resolve(ctx context.Context, code string) (Link, error) {
+	var row database.ShortLink
+	err := database.DBConn.WithContext(ctx).First(&row, "code = ?", code).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Link{}, ErrNotFound
+	}
+	if err != nil {
+		return Link{}, err
+	}
+
+	link := Link{Code: row.Code, FullURL: row.FullUrl, TransferId: row.TransferId}
+	if row.ExpiresAt != nil && time.Now().After(*row.ExpiresAt) {
+		return link, ErrExpired
+	}
+	return link, nil
+}
+
+// RecordClick stores one redirect of link.
+func RecordClick(ctx context.Context, link Link, userAgent, ip string) error {
+	if len(userAgent) > 512 {
+		// ตัดตรงขอบตัวอักษร ไม่งั้น MySQL strict mode ไม่รับ UTF-8 ที่ขาดครึ่ง
+		cut := 512
+		for cut > 0 && !utf8.RuneStart(userAgent[cut]) {
+			cut--
+		}
+		userAgent = userAgent[:cut]
+	}
+	return database.DBConn.WithContext(ctx).Create(&database.ShortLinkClick{
+		Code:       link.Code,
+		TransferId: link.TransferId,
+		UserAgent:  userAgent,
+		IP:         ip,
+		ClickedAt:  time.Now(),
+	}).Error
+}
+
+func newCode() (string, error) {
+	b := make([]byte, codeLength)
+	max := big.NewInt(int64(len(codeAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("short code: %w", err)
+		}
+		b[i] = codeAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,126 @@
+package shortlink
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"pond/env"
+)
+
+// Request is one link to shorten. TransferId is only used for bookkeeping.
+type Request struct {
+	FullURL    string
+	TransferId string
+}
+
+// Shortener turns a full download URL into a short one.
+type Shortener interface {
+	Shorten(ctx context.Context, req Request) (string, error)
+}
+
+// FromEnv builds the shortener used for report links, behind an in-memory
+// cache. By default it is the HTTP service at URL_ONE_GENERATE_SHOT_LINK
+// with the local MySQL shortener as fallback (when SHORTLINK_BASE_URL is
+// set); SHORTLINK_MODE=local uses only the local one. Local links expire
+// after SHORTLINK_TTL (default 30 days). Cached links are reused for
+// SHORTLINK_CACHE_TTL (default 1h), at most half of SHORTLINK_TTL.
+func FromEnv() (Shortener, error) {
+	var local *Local
+	cacheTTL := env.Duration("SHORTLINK_CACHE_TTL", time.Hour)
+	if base := os.Getenv("SHORTLINK_BASE_URL"); base != "" {
+		local = &Local{BaseURL: base, TTL: env.Duration("SHORTLINK_TTL", 30*24*time.Hour)}
+		if cacheTTL > local.TTL/2 {
+			cacheTTL = local.TTL / 2
+		}
+	}
+	cacheSize := env.Int("SHORTLINK_CACHE_SIZE", 10000)
+
+	var chain []Shortener
+	if os.Getenv("SHORTLINK_MODE") == "local" {
+		if local == nil {
+			return nil, errors.New("SHORTLINK_MODE=local needs SHORTLINK_BASE_URL")
+		}
+		return NewCache(local, cacheSize, cacheTTL), nil
+	}
+	if u := os.Getenv("URL_ONE_GENERATE_SHOT_LINK"); u != "" {
+		chain = append(chain, NewHTTP(u))
+	}
+	if local != nil {
+		chain = append(chain, local)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("set URL_ONE_GENERATE_SHOT_LINK or SHORTLINK_BASE_URL")
+	}
+
+	return NewCache(Fallback(chain), cacheSize, cacheTTL), nil
+}
+
+// Fallback tries each shortener in order and returns the first success.
+type Fallback []Shortener
+
+func (f Fallback) Shorten(ctx context.Context, req Request) (string, error) {
+	var err error
+	for i, s := range f {
+		var short string
+		short, err = s.Shorten(ctx, req)
+		if err == nil {
+			return short, nil
+		}
+		if i < len(f)-1 {
+			log.Printf("%s [SHORTLINK] %T failed, trying next: %v", req.TransferId, s, err)
+		}
+	}
+	return "", err
+}
+
+// Cache remembers short links by full URL so a retried or resent report
+// gets the same link without another call. Entries are dropped after ttl so
+// an expiring link is not handed out once it is close to its end.
+type Cache struct {
+	next Shortener
+	max  int
+	ttl  time.Duration
+
+	mu sync.Mutex
+	m  map[string]cached
+}
+
+type cached struct {
+	short   string
+	expires time.Time
+}
+
+func NewCache(next Shortener, max int, ttl time.Duration) *Cache {
+	return &Cache{next: next, max: max, ttl: ttl, m: map[string]cached{}}
+}
+
+func (c *Cache) Shorten(ctx context.Context, req Request) (string, error) {
+	c.mu.Lock()
+	e, ok := c.m[req.FullURL]
+	if ok && time.Now().After(e.expires) {
+		delete(c.m, req.FullURL)
+		ok = false
+	}
+	c.mu.Unlock()
+	if ok {
+		return e.short, nil
+	}
+
+	short, err := c.next.Shorten(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	if len(c.m) >= c.max {
+		// ไม่ต้องทำ LRU ลิงก์ส่วนใหญ่ใช้ครั้งเดียว ล้างทิ้งทั้งก้อนพอ
+		c.m = map[string]cached{}
+	}
+	c.m[req.FullURL] = cached{short: short, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return short, nil
+}
@@ -0,0 +1,58 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client sends signed requests to this service, e.g.
+//
+//	c := &signing.Client{BaseURL: "https://mail.example", APIKey: key, Secret: []byte(secret)}
+//	resp, err := c.Do(ctx, http.MethodPost, "/SMTP", body)
+type Client struct {
+	BaseURL string
+	APIKey  string
+	Secret  []byte
+	HTTP    *http.Client
+}
+
+func (c *Client) Do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := SignRequest(req, c.APIKey, c.Secret, body); err != nil {
+		return nil, err
+	}
+
+	h := c.HTTP
+	if h == nil {
+		h = http.DefaultClient
+	}
+	return h.Do(req)
+}
+
+// SignRequest sets the API key, timestamp, nonce and signature headers on
+// req. body must be the exact bytes req will send.
+func SignRequest(req *http.Request, apiKey string, secret, body []byte) error {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(b)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set(HeaderAPIKey, apiKey)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Sign(secret, req.Method, req.URL.RequestURI(), ts, nonce, body))
+	return nil
+}
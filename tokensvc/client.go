@@ -0,0 +1,192 @@
+package tokensvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"pond/env"
+)
+
+// Client talks to the token generation service behind URL_ONE_GENERATE_TOKEN,
+// and to its batch endpoint URL_LINK_GEN_TOKEN_PDF when BatchSize is set.
+type Client struct {
+	URL     string
+	HTTP    *http.Client
+	Retries int           // extra attempts after the first one
+	Backoff time.Duration // wait before the first retry, doubled after each
+
+	BatchURL  string
+	BatchKey  string
+	BatchSize int // 0 turns batch mode off
+}
+
+// NewFromEnv reads URL_ONE_GENERATE_TOKEN, TOKEN_TIMEOUT (default 10s),
+// TOKEN_RETRIES (default 2), TOKEN_BACKOFF (default 500ms) and, for batch
+// mode, URL_LINK_GEN_TOKEN_PDF, SECRECT_KEY and TOKEN_BATCH_SIZE.
+func NewFromEnv() *Client {
+	c := &Client{
+		URL:     os.Getenv("URL_ONE_GENERATE_TOKEN"),
+		HTTP:    &http.Client{Timeout: env.Duration("TOKEN_TIMEOUT", 10*time.Second)},
+		Retries: env.NonNegInt("TOKEN_RETRIES", 2),
+		Backoff: env.Duration("TOKEN_BACKOFF", 500*time.Millisecond),
+
+		BatchURL:  os.Getenv("URL_LINK_GEN_TOKEN_PDF"),
+		BatchKey:  os.Getenv("SECRECT_KEY"),
+		BatchSize: env.NonNegInt("TOKEN_BATCH_SIZE", 0),
+	}
+	if c.BatchURL == "" {
+		c.BatchSize = 0
+	}
+	return c
+}
+
+var errEmptyToken = errors.New("token service returned an empty token")
+
+// StatusError is a non-200 answer from the token service.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("token service returned HTTP %d: %s", e.Code, e.Body)
+}
+
+// Token returns the download token for transferId, retrying network errors,
+// HTTP 429 and 5xx with exponential backoff.
+func (c *Client) Token(ctx context.Context, transferId string) (string, error) {
+	var token string
+	err := c.retry(ctx, transferId, func() error {
+		var err error
+		token, err = c.request(ctx, transferId)
+		return err
+	})
+	return token, err
+}
+
+// Batch asks for the tokens of ids in one call. The result is keyed by
+// transfer id; ids the service left out or answered with an empty token are
+// missing from it.
+func (c *Client) Batch(ctx context.Context, reportType string, ids []string) (map[string]string, error) {
+	details := make([]map[string]string, len(ids))
+	for i, id := range ids {
+		details[i] = map[string]string{"transfer_id": id}
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"key":     c.BatchKey,
+		"type":    reportType,
+		"details": details,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []struct {
+		TransferId string `json:"transfer_id"`
+		Token      string `json:"token"`
+	}
+	label := fmt.Sprintf("batch(%d)", len(ids))
+	err = c.retry(ctx, label, func() error {
+		res = nil
+		return c.post(ctx, c.BatchURL, payload, &res)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]string, len(res))
+	for _, r := range res {
+		if r.Token != "" {
+			tokens[r.TransferId] = r.Token
+		}
+	}
+	return tokens, nil
+}
+
+func (c *Client) retry(ctx context.Context, label string, fn func() error) error {
+	delay := c.Backoff
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("%s [TOKEN] attempt %d/%d failed: %v", label, attempt, c.Retries+1, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+
+		err = fn()
+		if err == nil || !retryable(err) {
+			break
+		}
+	}
+	return err
+}
+
+func (c *Client) request(ctx context.Context, transferId string) (string, error) {
+	payload, err := json.Marshal(map[string]string{"transferId": transferId})
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := c.post(ctx, c.URL, payload, &res); err != nil {
+		return "", err
+	}
+	if res.Token == "" {
+		return "", errEmptyToken
+	}
+	return res.Token, nil
+}
+
+func (c *Client) post(ctx context.Context, url string, payload []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode token response: %w", err)
+	}
+	return nil
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, errEmptyToken) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code == http.StatusTooManyRequests || se.Code >= 500
+	}
+	// ตอบกลับมาแล้วแต่ parse ไม่ได้ ลองใหม่ก็คงได้ผลเหมือนเดิม
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return false
+	}
+	return true
+}